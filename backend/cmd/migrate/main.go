@@ -1,27 +1,49 @@
 package main
 
 import (
+	"database/sql"
+	"embed"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/lib/pq"
 	"github.com/rohitkeshwani07/chat/backend/internal/config"
 )
 
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// advisoryLockKey is an arbitrary, fixed key for a Postgres session
+// advisory lock. It's only used to serialize `up` across concurrent pod
+// starts; the value has no meaning beyond being unique to this binary.
+const advisoryLockKey = 0x63686174 // "chat"
+
 func main() {
 	logger := log.New(os.Stdout, "[MIGRATE] ", log.LstdFlags)
-	logger.Println("Starting database migration...")
 
-	// Load configuration
+	dryRun := flag.Bool("dry-run", false, "print the SQL that would run without applying it")
+	yes := flag.Bool("yes", false, "confirm the destructive drop command")
+	flag.Parse()
+	args := flag.Args()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		logger.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Build database URL
 	dbURL := fmt.Sprintf(
 		"postgres://%s:%s@%s:%d/%s?sslmode=%s",
 		cfg.Database.User,
@@ -32,44 +54,79 @@ func main() {
 		cfg.Database.SSLMode,
 	)
 
-	// Get migrations path from environment or use default
-	migrationsPath := os.Getenv("MIGRATIONS_PATH")
-	if migrationsPath == "" {
-		migrationsPath = "file:///migrations"
-	}
-
-	logger.Printf("Using migrations path: %s", migrationsPath)
 	logger.Printf("Connecting to database: %s@%s:%d/%s", cfg.Database.User, cfg.Database.Host, cfg.Database.Port, cfg.Database.Database)
 
-	// Create migration instance
-	m, err := migrate.New(migrationsPath, dbURL)
+	sourceDrv, err := iofs.New(migrationFiles, "migrations")
 	if err != nil {
-		logger.Fatalf("Failed to create migration instance: %v", err)
+		logger.Fatalf("Failed to load embedded migrations: %v", err)
 	}
-	defer m.Close()
+	defer sourceDrv.Close()
 
-	// Get command from argument (default: up)
-	command := "up"
-	if len(os.Args) > 1 {
-		command = os.Args[1]
+	m, err := migrate.NewWithSourceInstance("iofs", sourceDrv, dbURL)
+	if err != nil {
+		logger.Fatalf("Failed to create migration instance: %v", err)
 	}
+	defer m.Close()
 
 	logger.Printf("Running migration command: %s", command)
 
-	// Execute migration command
 	switch command {
 	case "up":
-		if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-			logger.Fatalf("Failed to run up migrations: %v", err)
+		if *dryRun {
+			printPendingSQL(logger, sourceDrv, m, source.Up)
+			break
 		}
+		withAdvisoryLock(logger, dbURL, func() {
+			if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+				logger.Fatalf("Failed to run up migrations: %v", err)
+			}
+		})
 		logger.Println("Migrations applied successfully")
 
 	case "down":
+		if *dryRun {
+			printPendingSQL(logger, sourceDrv, m, source.Down)
+			break
+		}
 		if err := m.Down(); err != nil && err != migrate.ErrNoChange {
 			logger.Fatalf("Failed to run down migrations: %v", err)
 		}
 		logger.Println("Migrations rolled back successfully")
 
+	case "goto":
+		if len(args) < 2 {
+			logger.Fatalf("goto command requires a target version argument")
+		}
+		version, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			logger.Fatalf("Invalid version number: %v", err)
+		}
+		if *dryRun {
+			printGotoSQL(logger, sourceDrv, m, uint(version))
+			break
+		}
+		if err := m.Migrate(uint(version)); err != nil && err != migrate.ErrNoChange {
+			logger.Fatalf("Failed to migrate to version %d: %v", version, err)
+		}
+		logger.Printf("Migrated to version %d", version)
+
+	case "steps":
+		if len(args) < 2 {
+			logger.Fatalf("steps command requires a +/-N argument")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			logger.Fatalf("Invalid step count: %v", err)
+		}
+		if *dryRun {
+			logger.Println("--dry-run isn't supported for steps; use --dry-run goto <version> for a SQL preview")
+			break
+		}
+		if err := m.Steps(n); err != nil && err != migrate.ErrNoChange {
+			logger.Fatalf("Failed to run %d step(s): %v", n, err)
+		}
+		logger.Printf("Ran %d step(s)", n)
+
 	case "version":
 		version, dirty, err := m.Version()
 		if err != nil {
@@ -78,11 +135,11 @@ func main() {
 		logger.Printf("Current migration version: %d (dirty: %v)", version, dirty)
 
 	case "force":
-		if len(os.Args) < 3 {
-			logger.Fatalf("Force command requires version argument")
+		if len(args) < 2 {
+			logger.Fatalf("force command requires a version argument")
 		}
-		var version int
-		if _, err := fmt.Sscanf(os.Args[2], "%d", &version); err != nil {
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
 			logger.Fatalf("Invalid version number: %v", err)
 		}
 		if err := m.Force(version); err != nil {
@@ -90,9 +147,219 @@ func main() {
 		}
 		logger.Printf("Forced migration to version %d", version)
 
+	case "drop":
+		if !*yes {
+			logger.Fatalf("drop is destructive; re-run with --yes to confirm")
+		}
+		if err := m.Drop(); err != nil {
+			logger.Fatalf("Failed to drop database: %v", err)
+		}
+		logger.Println("Database dropped")
+
+	case "status":
+		printStatus(logger, sourceDrv, m)
+
 	default:
-		logger.Fatalf("Unknown command: %s (valid commands: up, down, version, force)", command)
+		logger.Fatalf("Unknown command: %s (valid commands: up, down, goto, steps, version, force, drop, status)", command)
 	}
 
 	logger.Println("Migration completed successfully")
 }
+
+// withAdvisoryLock opens its own connection (separate from the one the
+// migrate library manages internally) and holds a Postgres session
+// advisory lock around fn, so that when multiple pods start at once and
+// all try to run `up`, only one actually applies migrations at a time.
+func withAdvisoryLock(logger *log.Logger, dbURL string, fn func()) {
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		logger.Fatalf("Failed to open database for advisory lock: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("SELECT pg_advisory_lock($1)", advisoryLockKey); err != nil {
+		logger.Fatalf("Failed to acquire migration advisory lock: %v", err)
+	}
+	defer func() {
+		if _, err := db.Exec("SELECT pg_advisory_unlock($1)", advisoryLockKey); err != nil {
+			logger.Printf("Failed to release migration advisory lock: %v", err)
+		}
+	}()
+
+	fn()
+}
+
+// printMigrationSQL prints a single migration's raw SQL, used by all the
+// --dry-run paths below.
+func printMigrationSQL(logger *log.Logger, read func(uint) (io.ReadCloser, string, error), version uint) {
+	r, identifier, err := read(version)
+	if err != nil {
+		logger.Fatalf("Failed to read migration %d: %v", version, err)
+	}
+	defer r.Close()
+
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		logger.Fatalf("Failed to read migration %d: %v", version, err)
+	}
+
+	logger.Printf("-- %d_%s --\n%s", version, identifier, contents)
+}
+
+// printPendingSQL walks the migrations that `up` or `down` would apply
+// from the current version and prints each one's SQL without running it.
+func printPendingSQL(logger *log.Logger, src source.Driver, m *migrate.Migrate, direction source.Direction) {
+	version, _, err := m.Version()
+	noVersionYet := err == migrate.ErrNilVersion
+	if err != nil && !noVersionYet {
+		logger.Fatalf("Failed to read current version: %v", err)
+	}
+
+	if direction == source.Up {
+		cursor, err := src.First()
+		if noVersionYet {
+			if err == os.ErrNotExist {
+				logger.Println("No migrations found")
+				return
+			}
+			if err != nil {
+				logger.Fatalf("Failed to find first migration: %v", err)
+			}
+			printMigrationSQL(logger, src.ReadUp, cursor)
+		} else {
+			cursor = version
+		}
+		for {
+			next, err := src.Next(cursor)
+			if err == os.ErrNotExist {
+				return
+			}
+			if err != nil {
+				logger.Fatalf("Failed to walk migrations: %v", err)
+			}
+			printMigrationSQL(logger, src.ReadUp, next)
+			cursor = next
+		}
+	}
+
+	if noVersionYet {
+		logger.Println("No applied migrations to roll back")
+		return
+	}
+	cursor := version
+	printMigrationSQL(logger, src.ReadDown, cursor)
+	for {
+		prev, err := src.Prev(cursor)
+		if err == os.ErrNotExist {
+			return
+		}
+		if err != nil {
+			logger.Fatalf("Failed to walk migrations: %v", err)
+		}
+		printMigrationSQL(logger, src.ReadDown, prev)
+		cursor = prev
+	}
+}
+
+// printGotoSQL prints the SQL that `goto target` would run, walking
+// forward or backward from the current version as needed.
+func printGotoSQL(logger *log.Logger, src source.Driver, m *migrate.Migrate, target uint) {
+	version, _, err := m.Version()
+	noVersionYet := err == migrate.ErrNilVersion
+	if err != nil && !noVersionYet {
+		logger.Fatalf("Failed to read current version: %v", err)
+	}
+	current := uint(0)
+	if !noVersionYet {
+		current = version
+	}
+
+	switch {
+	case target == current:
+		logger.Println("Already at target version; nothing to do")
+
+	case target > current:
+		cursor := current
+		first := noVersionYet
+		for {
+			var next uint
+			var err error
+			if first {
+				next, err = src.First()
+				first = false
+			} else {
+				next, err = src.Next(cursor)
+			}
+			if err == os.ErrNotExist {
+				return
+			}
+			if err != nil {
+				logger.Fatalf("Failed to walk migrations: %v", err)
+			}
+			if next > target {
+				return
+			}
+			printMigrationSQL(logger, src.ReadUp, next)
+			cursor = next
+		}
+
+	default:
+		cursor := current
+		for cursor > target {
+			printMigrationSQL(logger, src.ReadDown, cursor)
+			next, err := src.Prev(cursor)
+			if err == os.ErrNotExist {
+				return
+			}
+			if err != nil {
+				logger.Fatalf("Failed to walk migrations: %v", err)
+			}
+			cursor = next
+		}
+	}
+}
+
+// printStatus lists every embedded migration alongside whether it's been
+// applied against the current database.
+func printStatus(logger *log.Logger, src source.Driver, m *migrate.Migrate) {
+	version, dirty, err := m.Version()
+	noVersionYet := err == migrate.ErrNilVersion
+	if err != nil && !noVersionYet {
+		logger.Fatalf("Failed to read current version: %v", err)
+	}
+
+	cursor, err := src.First()
+	if err == os.ErrNotExist {
+		logger.Println("No migrations found")
+		return
+	}
+	if err != nil {
+		logger.Fatalf("Failed to list migrations: %v", err)
+	}
+
+	for {
+		r, identifier, err := src.ReadUp(cursor)
+		if err != nil {
+			logger.Fatalf("Failed to read migration %d: %v", cursor, err)
+		}
+		r.Close()
+
+		state := "pending"
+		if !noVersionYet && cursor <= version {
+			state = "applied"
+			if cursor == version && dirty {
+				state = "applied (dirty)"
+			}
+		}
+		logger.Printf("%6d  %-40s %s", cursor, identifier, state)
+
+		next, err := src.Next(cursor)
+		if err == os.ErrNotExist {
+			return
+		}
+		if err != nil {
+			logger.Fatalf("Failed to walk migrations: %v", err)
+		}
+		cursor = next
+	}
+}
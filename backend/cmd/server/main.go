@@ -3,116 +3,313 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/rohitkeshwani07/chat/backend/internal/auth"
 	"github.com/rohitkeshwani07/chat/backend/internal/buffer"
 	"github.com/rohitkeshwani07/chat/backend/internal/config"
 	"github.com/rohitkeshwani07/chat/backend/internal/handlers"
+	"github.com/rohitkeshwani07/chat/backend/internal/logging"
+	"github.com/rohitkeshwani07/chat/backend/internal/messagebus"
+	"github.com/rohitkeshwani07/chat/backend/internal/messagebus/rabbitmq"
+	"github.com/rohitkeshwani07/chat/backend/internal/metrics"
 	"github.com/rohitkeshwani07/chat/backend/internal/models"
 	natsClient "github.com/rohitkeshwani07/chat/backend/internal/nats"
+	"github.com/rohitkeshwani07/chat/backend/internal/presence"
+	"github.com/rohitkeshwani07/chat/backend/internal/ratelimit"
 	"github.com/rohitkeshwani07/chat/backend/internal/registry"
 	"github.com/rohitkeshwani07/chat/backend/internal/sse"
+	"github.com/rohitkeshwani07/chat/backend/internal/storage"
+	"github.com/rohitkeshwani07/chat/backend/internal/storage/postgres"
+	"github.com/rohitkeshwani07/chat/backend/internal/tracing"
 )
 
 func main() {
-	logger := log.New(os.Stdout, "[CHAT-SERVER] ", log.LstdFlags|log.Lshortfile)
-	logger.Println("Starting Chat Server...")
+	// The root logger's level/format aren't known until config.Load
+	// succeeds, so bootstrap logging errors before that point with a
+	// conservative default (info/json) and rebuild it once cfg is in hand.
+	logger := logging.New("json", "info", "server", "")
+	logger.Info("Starting Chat Server...")
 
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		logger.Fatalf("Failed to load configuration: %v", err)
+		logger.Error("Failed to load configuration", "error", err)
+		os.Exit(1)
 	}
 
-	logger.Printf("Configuration loaded. Pod ID: %s", cfg.Server.PodID)
+	logger = logging.New(cfg.Log.Format, cfg.Log.Level, "server", cfg.Server.PodID)
+	logger.Info("Configuration loaded", "pod_id", cfg.Server.PodID)
+
+	// cfgWatcher notifies subscribers after a successful SIGHUP-triggered
+	// reload; subscribers below retune their own knobs in place.
+	cfgWatcher := config.NewWatcher()
+
+	// Initialize Prometheus metrics registry, shared across subsystems
+	metricsRegistry := metrics.NewRegistry()
+
+	// Initialize OpenTelemetry tracing. When disabled, Init still installs
+	// the propagator and leaves the no-op TracerProvider in place, so every
+	// tracing.StartSpan call below is always safe to make.
+	tracingShutdown, err := tracing.Init(context.Background(), cfg.Tracing.Enabled, cfg.Tracing.OTLPEndpoint, cfg.Tracing.ServiceName, cfg.Server.PodID)
+	if err != nil {
+		logger.Error("Failed to initialize tracing", "error", err)
+		os.Exit(1)
+	}
 
 	// Initialize session registry (Redis)
-	logger.Println("Connecting to Redis...")
-	sessionRegistry, err := registry.New(
-		cfg.Redis.GetRedisAddr(),
+	logger.Info("Connecting to Redis...")
+	sessionRegistry, err := registry.NewSharded(
+		cfg.Redis.GetRedisAddrs(),
 		cfg.Redis.Password,
 		cfg.Redis.DB,
 	)
 	if err != nil {
-		logger.Fatalf("Failed to connect to Redis: %v", err)
+		logger.Error("Failed to connect to Redis", "error", err)
+		os.Exit(1)
 	}
 	defer sessionRegistry.Close()
-	logger.Println("Connected to Redis")
+	logger.Info("Connected to Redis")
 
-	// Initialize NATS client
-	logger.Println("Connecting to NATS...")
-	nats, err := natsClient.New(
-		cfg.NATS.URL,
-		cfg.Server.PodID,
-		cfg.NATS.MaxReconnects,
-		cfg.NATS.ReconnectWait,
-		logger,
+	// Initialize the message bus. A *natsClient.Client additionally supports
+	// JetStream-backed Last-Event-ID replay and presence-based direct pod
+	// routing; the RabbitMQ backend doesn't, so chunkReplayer stays nil and
+	// reconnecting SSE clients on that backend skip straight to live delivery
+	// unless the distributed buffer below is enabled and supplies replay.
+	var bus messagebus.Bus
+	var chunkReplayer sse.ChunkReplayer
+
+	switch cfg.MessageBus {
+	case "rabbitmq":
+		logger.Info("Connecting to RabbitMQ...")
+		rmq, err := rabbitmq.New(cfg.RabbitMQ.URL, cfg.Server.PodID, logger.With("component", "rabbitmq"), metricsRegistry)
+		if err != nil {
+			logger.Error("Failed to connect to RabbitMQ", "error", err)
+			os.Exit(1)
+		}
+		defer rmq.Close()
+		bus = rmq
+		logger.Info("Connected to RabbitMQ")
+
+	default:
+		logger.Info("Connecting to NATS...")
+		nats, err := natsClient.New(
+			cfg.NATS.URL,
+			cfg.Server.PodID,
+			cfg.NATS.MaxReconnects,
+			cfg.NATS.ReconnectWait,
+			logger.With("component", "nats"),
+			metricsRegistry,
+		)
+		if err != nil {
+			logger.Error("Failed to connect to NATS", "error", err)
+			os.Exit(1)
+		}
+		defer nats.Close()
+		bus = nats
+		chunkReplayer = nats
+		logger.Info("Connected to NATS")
+	}
+
+	// Initialize cross-pod presence tracking (always on; startup fails if
+	// the presence store is unreachable)
+	logger.Info("Connecting to presence store...")
+	sessionPresence, err := presence.NewRedisPresence(
+		cfg.Redis.GetRedisAddr(),
+		cfg.Redis.Password,
+		cfg.Redis.DB,
+		60*time.Second,
 	)
 	if err != nil {
-		logger.Fatalf("Failed to connect to NATS: %v", err)
+		logger.Error("Failed to connect to presence store", "error", err)
+		os.Exit(1)
+	}
+	defer sessionPresence.Close()
+	if nats, ok := bus.(*natsClient.Client); ok {
+		nats.SetPodLookup(sessionPresence)
+		nats.SetSessionPodLookup(sessionRegistry)
 	}
-	defer nats.Close()
-	logger.Println("Connected to NATS")
 
-	// Initialize SSE manager
-	sseManager := sse.NewManager(logger)
-	logger.Println("SSE Manager initialized")
+	// Initialize SSE manager, wired for Last-Event-ID replay (when the
+	// message bus supports it) and to presence for cross-pod routing
+	sseManager := sse.NewManager(logger.With("component", "sse"), chunkReplayer, sessionPresence, cfg.Server.PodID, 0, 0, metricsRegistry)
+	logger.Info("SSE Manager initialized")
 
-	// Start SSE heartbeat (every 30 seconds)
-	sseManager.StartHeartbeat(30 * time.Second)
+	// Start SSE heartbeat
+	sseManager.StartHeartbeat(cfg.Server.HeartbeatInterval)
 
-	// Initialize buffer manager
-	bufferManager := buffer.NewManager(
+	// Initialize buffer manager. When cfg.Buffer.Distributed is set, it's
+	// wrapped in a Redis Stream mirror so a reconnecting SSE client can be
+	// resumed from a pod other than the one that received the chunks; the
+	// rest of the service only relies on the buffer.ChunkManager interface
+	// either way. DistributedManager also implements sse.ChunkReplayer, so
+	// it's wired into sseManager below (via SetReplayer, since it can't
+	// exist before sseManager does: buffer.NewManager takes sseManager as a
+	// dependency), replacing any replayer the message bus provided: it
+	// restamps every chunk's Sequence with its own counter, so only it can
+	// resolve the Last-Event-ID clients send back.
+	var bufferManager buffer.ChunkManager
+	inMemoryBuffer := buffer.NewManager(
 		cfg.Buffer.MaxBuffersPerPod,
 		cfg.Buffer.MaxChunksPerBuffer,
 		cfg.Buffer.MaxBufferAge,
 		cfg.Buffer.CleanupInterval,
 		cfg.Buffer.MissingChunkTimeout,
+		sseManager,
+		bus,
+		metricsRegistry,
 	)
-	logger.Println("Buffer Manager initialized")
+	bufferManager = inMemoryBuffer
+	inMemoryBuffer.SetChunksMissingCallback(func(messageID string, missingChunkIDs []int) {
+		logger.Warn("Buffer has persistent gap, requesting resend", "message_id", messageID, "missing_chunk_ids", missingChunkIDs)
+	})
+
+	if cfg.Buffer.Distributed {
+		logger.Info("Connecting distributed buffer to Redis...")
+		distributedBuffer, err := buffer.NewDistributedManager(
+			inMemoryBuffer,
+			cfg.Redis.GetRedisAddr(),
+			cfg.Redis.Password,
+			cfg.Redis.DB,
+			cfg.Buffer.StreamMaxLen,
+			logger.With("component", "buffer"),
+		)
+		if err != nil {
+			logger.Error("Failed to connect distributed buffer to Redis", "error", err)
+			os.Exit(1)
+		}
+		defer distributedBuffer.Close()
+		bufferManager = distributedBuffer
+		sseManager.SetReplayer(distributedBuffer)
+		logger.Info("Distributed buffer connected")
+	}
+	logger.Info("Buffer Manager initialized")
 
 	// Start buffer cleanup
 	bufferManager.StartCleanup()
 	defer bufferManager.StopCleanup()
 
+	// Initialize persistent chat history (optional; nil when disabled, in
+	// which case the handlers skip persistence and the history endpoints
+	// respond 503).
+	var store storage.MessageStore
+	if cfg.Storage.Enabled {
+		logger.Info("Connecting to Postgres...")
+		pgStore, err := postgres.New(cfg.Database.GetDSN())
+		if err != nil {
+			logger.Error("Failed to connect to Postgres", "error", err)
+			os.Exit(1)
+		}
+		defer pgStore.Close()
+		logger.Info("Connected to Postgres")
+
+		writeBehind := storage.NewWriteBehind(pgStore, cfg.Storage.WriteQueueSize, logger.With("component", "storage"))
+		defer writeBehind.Close()
+		store = writeBehind
+
+		stopRetention := storage.StartRetention(store, cfg.Storage.Retention, cfg.Storage.RetentionInterval, logger.With("component", "storage"))
+		defer stopRetention()
+	}
+
+	// Retune heartbeat interval and buffer limits on SIGHUP without a
+	// restart; everything else (addresses, ports, credentials) still
+	// requires one.
+	cfgWatcher.Watch(func(newCfg *config.Config) {
+		sseManager.SetHeartbeatInterval(newCfg.Server.HeartbeatInterval)
+		bufferManager.Reconfigure(
+			newCfg.Buffer.MaxBuffersPerPod,
+			newCfg.Buffer.MaxChunksPerBuffer,
+			newCfg.Buffer.MaxBufferAge,
+			newCfg.Buffer.MissingChunkTimeout,
+		)
+		logger.Info("Configuration reloaded")
+	})
+	cfgWatcher.WatchSIGHUP(func(err error) {
+		logger.Error("Config reload failed, keeping previous configuration", "error", err)
+	})
+
 	// Initialize HTTP handlers
 	handler := handlers.New(
 		cfg.Server.PodID,
-		nats,
+		bus,
 		sessionRegistry,
 		sseManager,
 		bufferManager,
-		logger,
+		store,
+		logger.With("component", "handlers"),
+		metricsRegistry,
 	)
 
-	// Subscribe to NATS response chunks
-	logger.Println("Subscribing to NATS response subjects...")
-	if err := nats.SubscribeToResponses(handler.HandleResponseChunk); err != nil {
-		logger.Fatalf("Failed to subscribe to responses: %v", err)
+	// Subscribe to response chunks addressed to this pod
+	logger.Info("Subscribing to message bus response subjects...")
+	if err := bus.SubscribeToResponses(handler.HandleResponseChunk); err != nil {
+		logger.Error("Failed to subscribe to responses", "error", err)
+		os.Exit(1)
 	}
 
 	// Also subscribe to broadcast (fallback)
-	if err := nats.SubscribeToBroadcast(func(chunk *models.ResponseChunk) error {
+	if err := bus.SubscribeToBroadcast(func(chunk *models.ResponseChunk) error {
 		// Only handle if this pod has active connections for the session
 		if sseManager.HasActiveConnections(chunk.SessionID) {
 			return handler.HandleResponseChunk(chunk)
 		}
 		return nil
 	}); err != nil {
-		logger.Fatalf("Failed to subscribe to broadcast: %v", err)
+		logger.Error("Failed to subscribe to broadcast", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Subscribed to message bus subjects")
+
+	// Wrap /api/chat, /api/sse, and /api/ws in the request-ID middleware so
+	// every log line for a call (including the async HandleResponseChunk
+	// delivery it triggers) can be correlated by one ID, then in JWT
+	// verification and per-user rate limiting/connection capping when auth
+	// is enabled; otherwise the latter two run exactly as before, trusting
+	// the request-supplied user_id.
+	var chatHandler http.Handler = logging.Middleware(http.HandlerFunc(handler.HandleChat))
+	var sseHandler http.Handler = logging.Middleware(http.HandlerFunc(handler.HandleSSE))
+	var wsHandler http.Handler = logging.Middleware(http.HandlerFunc(handler.HandleWS))
+	var sessionsHandler http.Handler = http.HandlerFunc(handler.HandleSessions)
+	var sessionMessagesHandler http.Handler = http.HandlerFunc(handler.HandleSessionMessages)
+	if cfg.Auth.Enabled {
+		logger.Info("Initializing JWT auth...")
+		authVerifier, err := auth.NewVerifier(auth.Config{
+			Algorithm:           cfg.Auth.Algorithm,
+			HMACSecret:          cfg.Auth.HMACSecret,
+			JWKSURL:             cfg.Auth.JWKSURL,
+			JWKSRefreshInterval: cfg.Auth.JWKSRefreshInterval,
+		})
+		if err != nil {
+			logger.Error("Failed to initialize auth verifier", "error", err)
+			os.Exit(1)
+		}
+
+		chatLimiter := ratelimit.NewLimiter(sessionRegistry.Client(), cfg.RateLimit.ChatRequestsPerSecond, cfg.RateLimit.ChatBurst)
+		sseConnLimiter := ratelimit.NewConnLimiter(sessionRegistry.Client(), cfg.RateLimit.MaxSSEConnections, logger.With("component", "ratelimit"))
+
+		chatHandler = authVerifier.Middleware(chatLimiter.Middleware(chatHandler))
+		sseHandler = authVerifier.Middleware(sseConnLimiter.Middleware(sseHandler))
+		wsHandler = authVerifier.Middleware(sseConnLimiter.Middleware(wsHandler))
+		sessionsHandler = authVerifier.Middleware(sessionsHandler)
+		sessionMessagesHandler = authVerifier.Middleware(sessionMessagesHandler)
+		logger.Info("JWT auth and rate limiting enabled on /api/chat, /api/sse, and /api/ws")
+		logger.Info("JWT auth enabled on /api/sessions and /api/sessions/")
 	}
-	logger.Println("Subscribed to NATS subjects")
 
 	// Setup HTTP routes
 	mux := http.NewServeMux()
-	mux.HandleFunc("/api/chat", handler.HandleChat)
-	mux.HandleFunc("/api/sse", handler.HandleSSE)
+	mux.Handle("/api/chat", chatHandler)
+	mux.Handle("/api/sse", sseHandler)
+	mux.Handle("/api/ws", wsHandler)
+	mux.Handle("/api/sessions", sessionsHandler)
+	mux.Handle("/api/sessions/", sessionMessagesHandler)
 	mux.HandleFunc("/health", handler.HandleHealth)
+	mux.HandleFunc("/healthz", handler.HandleHealthz)
+	mux.Handle("/metrics", metricsRegistry.Handler())
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
 			http.NotFound(w, r)
@@ -136,9 +333,10 @@ func main() {
 
 	// Start server in goroutine
 	go func() {
-		logger.Printf("Server listening on %s", addr)
+		logger.Info("Server listening", "addr", addr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("Server failed: %v", err)
+			logger.Error("Server failed", "error", err)
+			os.Exit(1)
 		}
 	}()
 
@@ -147,23 +345,27 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	logger.Println("Shutting down server...")
+	logger.Info("Shutting down server...")
 
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Drain NATS connections
-	if err := nats.Drain(); err != nil {
-		logger.Printf("Failed to drain NATS: %v", err)
+	// Drain the message bus connection
+	if err := bus.Drain(); err != nil {
+		logger.Error("Failed to drain message bus", "error", err)
 	}
 
 	// Shutdown HTTP server
 	if err := server.Shutdown(ctx); err != nil {
-		logger.Printf("Server forced to shutdown: %v", err)
+		logger.Error("Server forced to shutdown", "error", err)
+	}
+
+	if err := tracingShutdown(ctx); err != nil {
+		logger.Error("Failed to shut down tracing", "error", err)
 	}
 
-	logger.Println("Server stopped")
+	logger.Info("Server stopped")
 }
 
 // enableCORS adds CORS headers to all responses
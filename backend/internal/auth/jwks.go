@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultJWKSRefreshInterval is used when a RS256 Config doesn't specify
+// one.
+const defaultJWKSRefreshInterval = 5 * time.Minute
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksDocument struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwksCache periodically refetches a JWKS document and caches the decoded
+// RSA public keys by kid, so RS256 verification doesn't cost a network
+// round trip per request and picks up key rotation automatically.
+type jwksCache struct {
+	url string
+
+	mu   sync.RWMutex
+	keys map[string]*rsa.PublicKey
+}
+
+// newJWKSCache fetches url once synchronously, so a misconfigured JWKS
+// endpoint fails at startup, then refreshes in the background.
+func newJWKSCache(url string, refreshInterval time.Duration) (*jwksCache, error) {
+	c := &jwksCache{url: url}
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+
+	if refreshInterval <= 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+	go c.refreshLoop(refreshInterval)
+
+	return c, nil
+}
+
+func (c *jwksCache) refresh() error {
+	resp, err := http.Get(c.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var doc jwksDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := k.toRSAPublicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+
+	return nil
+}
+
+// refreshLoop keeps serving the last-known-good keys if a refresh fails;
+// key rotation is expected to overlap old and new keys for this reason.
+func (c *jwksCache) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.refresh()
+	}
+}
+
+// Key returns the cached RSA public key for kid.
+func (c *jwksCache) Key(kid string) (*rsa.PublicKey, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	key, ok := c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no JWKS key found for kid %q", kid)
+	}
+	return key, nil
+}
+
+func (k jwk) toRSAPublicKey() (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
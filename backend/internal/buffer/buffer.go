@@ -1,83 +1,194 @@
 package buffer
 
 import (
+	"container/list"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/rohitkeshwani07/chat/backend/internal/metrics"
 	"github.com/rohitkeshwani07/chat/backend/internal/models"
 )
 
+// GapNotifier is notified when a buffer's oldest gap has been open longer
+// than MissingChunkTimeout, so the caller can surface it to the client
+// (e.g. as an SSE `event: gap` frame) instead of holding chunks forever.
+type GapNotifier interface {
+	SendGap(sessionID, messageID string, missingChunkIDs []int) error
+
+	// SendFailure tells sessionID's client that messageID has been given up
+	// on after maxResendAttempts unsuccessful retransmit requests, so the
+	// client can stop waiting instead of hanging forever.
+	SendFailure(sessionID, messageID, reason string) error
+}
+
+// ChunkRequester asks the producer side to re-publish specific chunks for a
+// message, e.g. as a NACK published back over the message bus. Requests are
+// driven off the same missingChunkTimeout as GapNotifier, from the cleanup
+// goroutine rather than per-chunk, so a buffer that never receives another
+// chunk still gets retried instead of silently waiting forever.
+type ChunkRequester interface {
+	RequestResend(messageID string, chunkIDs []int) error
+}
+
+// ChunksMissingCallback is fired by the cleanup goroutine each time it finds
+// a buffer with a persistent gap, alongside (and independent of) the
+// ChunkRequester retry itself — e.g. for logging or alerting on stalled
+// messages without having to poll GetState.
+type ChunksMissingCallback func(messageID string, missingChunkIDs []int)
+
+// maxResendAttempts bounds how many times the cleanup goroutine will ask a
+// ChunkRequester to re-publish a buffer's missing chunks before giving up
+// and marking the buffer failed.
+const maxResendAttempts = 3
+
+// ChunkManager is the interface handlers.Handler and cmd/server depend on,
+// implemented by Manager (in-memory only) and DistributedManager (adds a
+// Redis Stream mirror so a pod other than the one that received a chunk can
+// still deliver it). Swapping implementations doesn't change call sites.
+type ChunkManager interface {
+	// SendChunk buffers chunk, flushes any now-contiguous prefix, and
+	// reports whether the message is complete.
+	SendChunk(chunk *models.ResponseChunk) (chunksToSend []*models.ResponseChunk, isComplete bool, err error)
+
+	// FinalizeMessage assembles the complete message and removes it from
+	// the buffer.
+	FinalizeMessage(messageID string) (*models.Message, error)
+
+	// GetState returns the current state of a buffer.
+	GetState(messageID string) (*models.BufferState, error)
+
+	// GetBufferCount returns the number of active buffers.
+	GetBufferCount() int
+
+	// StartCleanup starts the background cleanup goroutine.
+	StartCleanup()
+
+	// StopCleanup stops the background cleanup goroutine.
+	StopCleanup()
+
+	// Reconfigure updates size and timeout limits in place.
+	Reconfigure(maxBuffers, maxChunks int, maxAge, missingChunkTimeout time.Duration)
+}
+
 // ChunkBuffer holds chunks for a single message being streamed
 type ChunkBuffer struct {
-	SessionID    string
-	MessageID    string
-	Chunks       map[int]*models.ResponseChunk
-	MaxChunkID   int
-	NextToSend   int
-	IsFinal      bool
-	FinalChunkID int
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	mu           sync.RWMutex
+	SessionID      string
+	MessageID      string
+	Chunks         map[int]*models.ResponseChunk
+	MaxChunkID     int
+	NextToSend     int
+	IsFinal        bool
+	FinalChunkID   int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	GapSince       time.Time // zero value means no gap currently open
+	GapNotified    bool
+	ResendAttempts int            // number of times the cleanup loop has asked for a resend of this buffer's gap
+	Failed         bool           // true once ResendAttempts has exceeded maxResendAttempts
+	FailureReason  string         // set alongside Failed
+	element        *list.Element  // position in the manager's LRU list
+	mu             sync.RWMutex
 }
 
 // Manager manages all active chunk buffers for a pod
 type Manager struct {
 	buffers             map[string]*ChunkBuffer
+	lru                 *list.List // front = most recently touched
 	mu                  sync.RWMutex
 	maxBuffersPerPod    int
 	maxChunksPerBuffer  int
 	maxBufferAge        time.Duration
 	cleanupInterval     time.Duration
 	missingChunkTimeout time.Duration
+	notifier            GapNotifier
+	requester           ChunkRequester
+	missingCallback     ChunksMissingCallback
 	stopCleanup         chan struct{}
+	metrics             *metrics.Registry
 }
 
-// NewManager creates a new buffer manager
-func NewManager(maxBuffers, maxChunks int, maxAge, cleanupInterval, missingChunkTimeout time.Duration) *Manager {
+// NewManager creates a new buffer manager. notifier may be nil, in which
+// case persistent gaps are tracked internally (visible via GetState) but
+// never surfaced to clients. requester may also be nil, in which case the
+// cleanup loop still detects and escalates persistent gaps but never asks
+// for a retransmit. metricsRegistry may be nil to skip metrics.
+func NewManager(maxBuffers, maxChunks int, maxAge, cleanupInterval, missingChunkTimeout time.Duration, notifier GapNotifier, requester ChunkRequester, metricsRegistry *metrics.Registry) *Manager {
 	return &Manager{
 		buffers:             make(map[string]*ChunkBuffer),
+		lru:                 list.New(),
 		maxBuffersPerPod:    maxBuffers,
 		maxChunksPerBuffer:  maxChunks,
 		maxBufferAge:        maxAge,
 		cleanupInterval:     cleanupInterval,
 		missingChunkTimeout: missingChunkTimeout,
+		notifier:            notifier,
+		requester:           requester,
 		stopCleanup:         make(chan struct{}),
+		metrics:             metricsRegistry,
 	}
 }
 
-// AddChunk adds a chunk to the appropriate buffer
-func (m *Manager) AddChunk(chunk *models.ResponseChunk) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// SetChunksMissingCallback wires an optional hook the cleanup loop fires
+// alongside each ChunkRequester retry, e.g. for logging or alerting.
+func (m *Manager) SetChunksMissingCallback(cb ChunksMissingCallback) {
+	m.missingCallback = cb
+}
+
+// getOrCreateBuffer returns the buffer for messageID, creating one and
+// evicting the least-recently-touched buffer if the pod is at capacity.
+// Caller must hold m.mu.
+func (m *Manager) getOrCreateBuffer(chunk *models.ResponseChunk) *ChunkBuffer {
+	if buffer, exists := m.buffers[chunk.MessageID]; exists {
+		m.lru.MoveToFront(buffer.element)
+		return buffer
+	}
 
-	// Check global buffer limit
 	if len(m.buffers) >= m.maxBuffersPerPod {
-		return fmt.Errorf("buffer limit reached: %d buffers", m.maxBuffersPerPod)
+		m.evictLRULocked()
 	}
 
-	// Get or create buffer for this message
-	buffer, exists := m.buffers[chunk.MessageID]
-	if !exists {
-		buffer = &ChunkBuffer{
-			SessionID:  chunk.SessionID,
-			MessageID:  chunk.MessageID,
-			Chunks:     make(map[int]*models.ResponseChunk),
-			NextToSend: 0,
-			CreatedAt:  time.Now(),
-		}
-		m.buffers[chunk.MessageID] = buffer
+	buffer := &ChunkBuffer{
+		SessionID:  chunk.SessionID,
+		MessageID:  chunk.MessageID,
+		Chunks:     make(map[int]*models.ResponseChunk),
+		NextToSend: 0,
+		CreatedAt:  time.Now(),
+	}
+	buffer.element = m.lru.PushFront(chunk.MessageID)
+	m.buffers[chunk.MessageID] = buffer
+	m.metrics.SetBufferedChunks(len(m.buffers))
+	return buffer
+}
+
+// evictLRULocked drops the least-recently-touched buffer to make room for a
+// new one. Caller must hold m.mu.
+func (m *Manager) evictLRULocked() {
+	oldest := m.lru.Back()
+	if oldest == nil {
+		return
 	}
+	messageID := oldest.Value.(string)
+	m.lru.Remove(oldest)
+	delete(m.buffers, messageID)
+	m.metrics.IncBufferEviction()
+}
+
+// AddChunk adds a chunk to the appropriate buffer
+func (m *Manager) AddChunk(chunk *models.ResponseChunk) error {
+	m.mu.Lock()
+	buffer := m.getOrCreateBuffer(chunk)
+	maxChunksPerBuffer := m.maxChunksPerBuffer
+	m.mu.Unlock()
 
 	buffer.mu.Lock()
 	defer buffer.mu.Unlock()
 
 	// Check per-buffer chunk limit
-	if len(buffer.Chunks) >= m.maxChunksPerBuffer {
+	if len(buffer.Chunks) >= maxChunksPerBuffer {
 		return fmt.Errorf("chunk limit reached for message %s: %d chunks",
-			chunk.MessageID, m.maxChunksPerBuffer)
+			chunk.MessageID, maxChunksPerBuffer)
 	}
 
 	// Check for duplicate
@@ -106,6 +217,14 @@ func (m *Manager) AddChunk(chunk *models.ResponseChunk) error {
 		buffer.FinalChunkID = chunk.ChunkID
 	}
 
+	// Track (or clear) the out-of-order gap at the head of the buffer.
+	if _, blocked := buffer.Chunks[buffer.NextToSend]; blocked {
+		buffer.GapSince = time.Time{}
+		buffer.GapNotified = false
+	} else if buffer.GapSince.IsZero() {
+		buffer.GapSince = time.Now()
+	}
+
 	return nil
 }
 
@@ -141,19 +260,84 @@ func (m *Manager) GetNextChunks(messageID string) ([]*models.ResponseChunk, bool
 		}
 	}
 
+	if len(chunksToSend) > 0 {
+		buffer.GapSince = time.Time{}
+		buffer.GapNotified = false
+	}
+
 	// Determine if message is complete
 	isComplete := buffer.IsFinal && buffer.NextToSend > buffer.FinalChunkID
 
 	return chunksToSend, isComplete, nil
 }
 
+// SendChunk is the single entry point handlers should use: it buffers the
+// chunk, flushes any now-contiguous prefix, reports whether the message is
+// complete, and fires the gap notifier if the head-of-line gap has been
+// open longer than missingChunkTimeout.
+func (m *Manager) SendChunk(chunk *models.ResponseChunk) (chunksToSend []*models.ResponseChunk, isComplete bool, err error) {
+	if err := m.AddChunk(chunk); err != nil {
+		return nil, false, fmt.Errorf("failed to buffer chunk: %w", err)
+	}
+
+	chunksToSend, isComplete, err = m.GetNextChunks(chunk.MessageID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get next chunks: %w", err)
+	}
+
+	m.checkGap(chunk.SessionID, chunk.MessageID)
+
+	return chunksToSend, isComplete, nil
+}
+
+// checkGap notifies the GapNotifier once per gap episode if the head of the
+// buffer has been blocked longer than missingChunkTimeout.
+func (m *Manager) checkGap(sessionID, messageID string) {
+	if m.notifier == nil {
+		return
+	}
+
+	m.mu.RLock()
+	buffer, exists := m.buffers[messageID]
+	m.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	m.mu.RLock()
+	missingChunkTimeout := m.missingChunkTimeout
+	m.mu.RUnlock()
+
+	buffer.mu.Lock()
+	shouldNotify := !buffer.GapSince.IsZero() &&
+		!buffer.GapNotified &&
+		time.Since(buffer.GapSince) >= missingChunkTimeout
+	var missing []int
+	if shouldNotify {
+		buffer.GapNotified = true
+		for i := buffer.NextToSend; i <= buffer.MaxChunkID; i++ {
+			if _, ok := buffer.Chunks[i]; !ok {
+				missing = append(missing, i)
+			}
+		}
+	}
+	buffer.mu.Unlock()
+
+	if shouldNotify && len(missing) > 0 {
+		m.metrics.IncBufferGapTimeout()
+		_ = m.notifier.SendGap(sessionID, messageID, missing)
+	}
+}
+
 // FinalizeMessage assembles the complete message and removes it from buffers
 func (m *Manager) FinalizeMessage(messageID string) (*models.Message, error) {
 	m.mu.Lock()
 	buffer, exists := m.buffers[messageID]
 	if exists {
+		m.lru.Remove(buffer.element)
 		delete(m.buffers, messageID) // Remove from active buffers
 	}
+	m.metrics.SetBufferedChunks(len(m.buffers))
 	m.mu.Unlock()
 
 	if !exists {
@@ -226,6 +410,8 @@ func (m *Manager) GetState(messageID string) (*models.BufferState, error) {
 	state := &models.BufferState{
 		TotalReceived: len(buffer.Chunks),
 		TotalSent:     buffer.NextToSend,
+		Failed:        buffer.Failed,
+		FailureReason: buffer.FailureReason,
 	}
 
 	if buffer.IsFinal {
@@ -264,34 +450,116 @@ func (m *Manager) StopCleanup() {
 	close(m.stopCleanup)
 }
 
-// cleanup removes stale buffers
+// resendNotice is a pending ChunkRequester.RequestResend/ChunksMissingCallback
+// call cleanup collected while holding m.mu, to fire after releasing it.
+type resendNotice struct {
+	messageID string
+	missing   []int
+}
+
+// failureNotice is a pending GapNotifier.SendFailure call cleanup collected
+// while holding m.mu, to fire after releasing it.
+type failureNotice struct {
+	sessionID string
+	messageID string
+	reason    string
+}
+
+// cleanup removes stale buffers and drives the missing-chunk NACK and
+// escalation protocol: a buffer whose oldest gap has been open longer than
+// missingChunkTimeout gets a resend requested (and ChunksMissingCallback
+// fired) once per cleanup pass; once that's happened more than
+// maxResendAttempts times without the gap closing, the buffer is marked
+// failed, the client is told via GapNotifier.SendFailure, and the buffer is
+// removed rather than left wedged forever.
 func (m *Manager) cleanup() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 
 	now := time.Now()
 	staleThreshold := now.Add(-m.maxBufferAge)
+	missingChunkTimeout := m.missingChunkTimeout
 
 	var staleBuffers []string
+	var resends []resendNotice
+	var failures []failureNotice
 
 	for messageID, buffer := range m.buffers {
-		buffer.mu.RLock()
+		buffer.mu.Lock()
 		isStale := buffer.UpdatedAt.Before(staleThreshold)
-		isFinal := buffer.IsFinal
-		buffer.mu.RUnlock()
+		// isComplete mirrors the isComplete check GetNextChunks returns: the
+		// final chunk can arrive before earlier ones (reordered delivery),
+		// so buffer.IsFinal alone doesn't mean the gap is closed. Gating on
+		// buffer.IsFinal here would skip the resend/escalation path below
+		// and delete the buffer on the very next tick, losing the message
+		// with no retransmit ever attempted.
+		isComplete := buffer.IsFinal && buffer.NextToSend > buffer.FinalChunkID
+		isFailed := buffer.Failed
+
+		if !isComplete && !isFailed && !buffer.GapSince.IsZero() && now.Sub(buffer.GapSince) >= missingChunkTimeout {
+			var missing []int
+			for i := buffer.NextToSend; i <= buffer.MaxChunkID; i++ {
+				if _, ok := buffer.Chunks[i]; !ok {
+					missing = append(missing, i)
+				}
+			}
+			if len(missing) > 0 {
+				buffer.ResendAttempts++
+				if buffer.ResendAttempts > maxResendAttempts {
+					buffer.Failed = true
+					buffer.FailureReason = fmt.Sprintf("gave up after %d resend attempts for missing chunks %v", maxResendAttempts, missing)
+					isFailed = true
+					failures = append(failures, failureNotice{sessionID: buffer.SessionID, messageID: messageID, reason: buffer.FailureReason})
+				} else {
+					resends = append(resends, resendNotice{messageID: messageID, missing: missing})
+				}
+			}
+		}
+		buffer.mu.Unlock()
 
 		// Remove if:
 		// 1. No updates in maxBufferAge AND not finalized (likely stuck)
-		// 2. Finalized but still in memory (should have been cleaned up)
-		if isStale || isFinal {
+		// 2. Actually complete (final chunk arrived and no gap remains) but
+		//    still in memory (should have been cleaned up)
+		// 3. Failed out of the resend protocol above
+		if isStale || isComplete || isFailed {
 			staleBuffers = append(staleBuffers, messageID)
+			if isStale && !isComplete && !isFailed {
+				m.metrics.IncBufferStall()
+			}
+			if isFailed {
+				m.metrics.IncBufferFailure()
+			}
 		}
 	}
 
 	// Remove stale buffers
 	for _, messageID := range staleBuffers {
+		buffer := m.buffers[messageID]
+		m.lru.Remove(buffer.element)
 		delete(m.buffers, messageID)
 	}
+	m.metrics.SetBufferedChunks(len(m.buffers))
+
+	requester := m.requester
+	missingCallback := m.missingCallback
+	notifier := m.notifier
+	m.mu.Unlock()
+
+	for _, r := range resends {
+		if missingCallback != nil {
+			missingCallback(r.messageID, r.missing)
+		}
+		if requester != nil {
+			_ = requester.RequestResend(r.messageID, r.missing)
+			m.metrics.IncBufferResendRequested()
+		}
+	}
+
+	if notifier != nil {
+		for _, f := range failures {
+			_ = notifier.SendFailure(f.sessionID, f.messageID, f.reason)
+		}
+	}
 }
 
 // GetBufferCount returns the number of active buffers
@@ -300,3 +568,16 @@ func (m *Manager) GetBufferCount() int {
 	defer m.mu.RUnlock()
 	return len(m.buffers)
 }
+
+// Reconfigure updates the manager's size and timeout limits in place, so a
+// config hot-reload can retune them without restarting the process. It
+// does not touch the cleanup interval, since that's bound to an
+// already-running ticker in StartCleanup.
+func (m *Manager) Reconfigure(maxBuffers, maxChunks int, maxAge, missingChunkTimeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxBuffersPerPod = maxBuffers
+	m.maxChunksPerBuffer = maxChunks
+	m.maxBufferAge = maxAge
+	m.missingChunkTimeout = missingChunkTimeout
+}
@@ -0,0 +1,182 @@
+package buffer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rohitkeshwani07/chat/backend/internal/models"
+)
+
+// mockGapNotifier records SendGap/SendFailure calls for assertions.
+type mockGapNotifier struct {
+	mu       sync.Mutex
+	gaps     int
+	failures []failureNotice
+}
+
+func (n *mockGapNotifier) SendGap(sessionID, messageID string, missingChunkIDs []int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.gaps++
+	return nil
+}
+
+func (n *mockGapNotifier) SendFailure(sessionID, messageID, reason string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.failures = append(n.failures, failureNotice{sessionID: sessionID, messageID: messageID, reason: reason})
+	return nil
+}
+
+// mockChunkRequester records RequestResend calls and, if closeGapOnResend is
+// set, supplies the missing chunk to the manager right away, simulating a
+// producer that successfully retransmits.
+type mockChunkRequester struct {
+	mu               sync.Mutex
+	requests         int
+	manager          *Manager
+	closeGapOnResend *models.ResponseChunk
+}
+
+func (r *mockChunkRequester) RequestResend(messageID string, chunkIDs []int) error {
+	r.mu.Lock()
+	r.requests++
+	chunk := r.closeGapOnResend
+	r.mu.Unlock()
+
+	if chunk != nil {
+		_ = r.manager.AddChunk(chunk)
+	}
+	return nil
+}
+
+func (r *mockChunkRequester) requestCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+// TestCleanupKeepsReorderedFinalAlive simulates the final chunk of a message
+// arriving before an earlier one: the buffer is IsFinal but still has a gap
+// (NextToSend <= FinalChunkID), so cleanup must neither delete it nor skip
+// the resend protocol.
+func TestCleanupKeepsReorderedFinalAlive(t *testing.T) {
+	requester := &mockChunkRequester{}
+	m := NewManager(100, 1000, time.Hour, time.Hour, time.Millisecond, nil, requester, nil)
+	requester.manager = m
+
+	messageID := "msg-reordered"
+	final := &models.ResponseChunk{SessionID: "s1", MessageID: messageID, ChunkID: 1, Chunk: "b", IsFinal: true}
+	if err := m.AddChunk(final); err != nil {
+		t.Fatalf("AddChunk(final): %v", err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	m.cleanup()
+
+	if m.GetBufferCount() != 1 {
+		t.Fatalf("buffer was removed despite an open gap before the final chunk")
+	}
+	if requester.requestCount() != 1 {
+		t.Fatalf("expected a resend request for the missing chunk 0, got %d", requester.requestCount())
+	}
+
+	// Now the missing chunk 0 arrives and the buffer really is complete.
+	if err := m.AddChunk(&models.ResponseChunk{SessionID: "s1", MessageID: messageID, ChunkID: 0, Chunk: "a"}); err != nil {
+		t.Fatalf("AddChunk(0): %v", err)
+	}
+	chunks, isComplete, err := m.GetNextChunks(messageID)
+	if err != nil {
+		t.Fatalf("GetNextChunks: %v", err)
+	}
+	if !isComplete || len(chunks) != 2 {
+		t.Fatalf("expected message complete with 2 chunks, got isComplete=%v chunks=%d", isComplete, len(chunks))
+	}
+
+	m.cleanup()
+	if m.GetBufferCount() != 0 {
+		t.Fatalf("expected buffer to be removed once actually complete")
+	}
+}
+
+// TestCleanupFailsAfterMaxResendAttempts simulates a chunk that's
+// permanently missing: the requester never supplies it, so cleanup must
+// give up after maxResendAttempts and report the failure via SendFailure.
+func TestCleanupFailsAfterMaxResendAttempts(t *testing.T) {
+	notifier := &mockGapNotifier{}
+	requester := &mockChunkRequester{}
+	m := NewManager(100, 1000, time.Hour, time.Hour, time.Millisecond, notifier, requester, nil)
+	requester.manager = m
+
+	messageID := "msg-permanent-gap"
+	if err := m.AddChunk(&models.ResponseChunk{SessionID: "s1", MessageID: messageID, ChunkID: 1, Chunk: "b"}); err != nil {
+		t.Fatalf("AddChunk: %v", err)
+	}
+
+	for i := 0; i <= maxResendAttempts; i++ {
+		time.Sleep(2 * time.Millisecond)
+		m.cleanup()
+	}
+
+	if requester.requestCount() != maxResendAttempts {
+		t.Fatalf("expected %d resend requests before giving up, got %d", maxResendAttempts, requester.requestCount())
+	}
+	if len(notifier.failures) != 1 {
+		t.Fatalf("expected exactly one SendFailure call, got %d", len(notifier.failures))
+	}
+	if notifier.failures[0].messageID != messageID {
+		t.Fatalf("SendFailure messageID = %q, want %q", notifier.failures[0].messageID, messageID)
+	}
+	if m.GetBufferCount() != 0 {
+		t.Fatalf("expected failed buffer to be removed")
+	}
+}
+
+// TestCleanupResumesAfterResendCloses simulates a gap that's resolved by a
+// retransmit before maxResendAttempts is exhausted: once RequestResend
+// supplies the missing chunk, cleanup must stop escalating and delivery
+// must resume normally.
+func TestCleanupResumesAfterResendCloses(t *testing.T) {
+	notifier := &mockGapNotifier{}
+	requester := &mockChunkRequester{}
+	m := NewManager(100, 1000, time.Hour, time.Hour, time.Millisecond, notifier, requester, nil)
+	requester.manager = m
+
+	messageID := "msg-resend-closes"
+	if err := m.AddChunk(&models.ResponseChunk{SessionID: "s1", MessageID: messageID, ChunkID: 1, Chunk: "b", IsFinal: true}); err != nil {
+		t.Fatalf("AddChunk: %v", err)
+	}
+
+	// The next resend request will be answered with the missing chunk 0,
+	// simulating a producer that successfully retransmits.
+	requester.closeGapOnResend = &models.ResponseChunk{SessionID: "s1", MessageID: messageID, ChunkID: 0, Chunk: "a"}
+
+	time.Sleep(2 * time.Millisecond)
+	m.cleanup()
+
+	if requester.requestCount() != 1 {
+		t.Fatalf("expected exactly one resend request, got %d", requester.requestCount())
+	}
+	if len(notifier.failures) != 0 {
+		t.Fatalf("gap closed via resend, expected no SendFailure calls, got %d", len(notifier.failures))
+	}
+
+	chunks, isComplete, err := m.GetNextChunks(messageID)
+	if err != nil {
+		t.Fatalf("GetNextChunks: %v", err)
+	}
+	if !isComplete || len(chunks) != 2 {
+		t.Fatalf("expected delivery to resume with 2 chunks, got isComplete=%v chunks=%d", isComplete, len(chunks))
+	}
+
+	// A further cleanup pass should now remove the buffer since it's truly
+	// complete, without any additional resend attempts.
+	m.cleanup()
+	if requester.requestCount() != 1 {
+		t.Fatalf("expected no further resend attempts once the gap closed, got %d", requester.requestCount())
+	}
+	if m.GetBufferCount() != 0 {
+		t.Fatalf("expected buffer to be removed after completion")
+	}
+}
@@ -0,0 +1,218 @@
+package buffer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rohitkeshwani07/chat/backend/internal/models"
+)
+
+// defaultStreamMaxLen bounds how many chunks a single session's Redis
+// Stream retains when DistributedManager isn't given an explicit value.
+const defaultStreamMaxLen = 10000
+
+// DistributedManager wraps a Manager with a Redis Stream mirror, so a pod
+// other than the one that received a chunk can still replay it: every chunk
+// SendChunk buffers is also XADD'd to a per-session stream, and a client
+// that reconnects to a different pod can catch up via ReplaySince before
+// switching to live delivery on that pod. Buffering itself stays exactly
+// the Manager behavior; Redis only adds durability and cross-pod replay.
+// DistributedManager implements sse.ChunkReplayer directly, the same way
+// *nats.Client does, so main.go can wire either one into sse.NewManager
+// interchangeably.
+type DistributedManager struct {
+	*Manager
+	client *redis.Client
+	ctx    context.Context
+	maxLen int64
+	logger *slog.Logger
+}
+
+// streamKey returns the Redis Stream key a session's chunks are mirrored to.
+func streamKey(sessionID string) string {
+	return fmt.Sprintf("chunks:%s", sessionID)
+}
+
+// seqKey returns the Redis key backing a session's monotonic chunk sequence
+// counter, which doubles as that session's Redis Stream entry IDs (each
+// mirrored as "<seq>-0") so a Last-Event-ID cursor round-trips through
+// ReplaySince without ever having to parse a Redis Stream ID on the way in.
+func seqKey(sessionID string) string {
+	return fmt.Sprintf("chunks:seq:%s", sessionID)
+}
+
+// NewDistributedManager wraps manager with a Redis Stream mirror, reusing
+// manager's own metrics registry. maxLen bounds stream retention via XADD's
+// approximate MAXLEN (<= 0 uses defaultStreamMaxLen).
+func NewDistributedManager(manager *Manager, addr, password string, db int, maxLen int64, logger *slog.Logger) (*DistributedManager, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	if maxLen <= 0 {
+		maxLen = defaultStreamMaxLen
+	}
+
+	return &DistributedManager{
+		Manager: manager,
+		client:  client,
+		ctx:     ctx,
+		maxLen:  maxLen,
+		logger:  logger,
+	}, nil
+}
+
+// SendChunk buffers chunk exactly as Manager.SendChunk does, then mirrors it
+// into the message's Redis Stream. Mirroring is best-effort: a Redis error
+// is logged but never blocks live delivery, so Redis being unavailable
+// degrades to in-memory-only behavior (the same as a plain Manager) rather
+// than failing the request.
+func (d *DistributedManager) SendChunk(chunk *models.ResponseChunk) (chunksToSend []*models.ResponseChunk, isComplete bool, err error) {
+	chunksToSend, isComplete, err = d.Manager.SendChunk(chunk)
+	if err != nil {
+		return nil, false, err
+	}
+
+	d.mirrorChunk(chunk)
+
+	return chunksToSend, isComplete, nil
+}
+
+// mirrorChunk assigns chunk its session-scoped sequence number (the same
+// role ack.Sequence plays for nats.Client) and XADDs it to the session's
+// stream, trimmed to roughly maxLen entries.
+func (d *DistributedManager) mirrorChunk(chunk *models.ResponseChunk) {
+	metadataJSON, err := json.Marshal(chunk.Metadata)
+	if err != nil {
+		d.logMirrorError(chunk, err)
+		return
+	}
+
+	seq, err := d.client.Incr(d.ctx, seqKey(chunk.SessionID)).Result()
+	if err != nil {
+		d.logMirrorError(chunk, err)
+		return
+	}
+	chunk.Sequence = uint64(seq)
+
+	args := &redis.XAddArgs{
+		Stream: streamKey(chunk.SessionID),
+		ID:     fmt.Sprintf("%d-0", seq),
+		MaxLen: d.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"message_id": chunk.MessageID,
+			"chunk_id":   chunk.ChunkID,
+			"chunk_type": chunk.ChunkType,
+			"chunk":      chunk.Chunk,
+			"is_final":   chunk.IsFinal,
+			"metadata":   string(metadataJSON),
+		},
+	}
+
+	if err := d.client.XAdd(d.ctx, args).Err(); err != nil {
+		d.logMirrorError(chunk, err)
+	}
+}
+
+func (d *DistributedManager) logMirrorError(chunk *models.ResponseChunk, err error) {
+	d.metrics.IncBufferMirrorError()
+	if d.logger != nil {
+		d.logger.Warn("Failed to mirror chunk to Redis", "message_id", chunk.MessageID, "chunk_id", chunk.ChunkID, "error", err)
+	}
+}
+
+// FinalizeMessage assembles the complete message exactly as
+// Manager.FinalizeMessage does. The mirrored stream is keyed by session (see
+// streamKey) and can span several messages in the same session, so
+// finalizing one message does not delete or trim it; retention is left to
+// maxLen trimming, the same way
+// nats.Client relies on its JetStream stream's MaxAge rather than deleting
+// per message.
+func (d *DistributedManager) FinalizeMessage(messageID string) (*models.Message, error) {
+	return d.Manager.FinalizeMessage(messageID)
+}
+
+// ReplaySince replays chunks mirrored for sessionID with a sequence number
+// after lastSeq, calling emit for each in order. lastSeq of 0 replays the
+// entire retained stream. It implements sse.ChunkReplayer, letting
+// sse.Manager resume a client across pods the same way it already does for
+// *nats.Client: catching it up before switching it to live delivery.
+func (d *DistributedManager) ReplaySince(sessionID string, lastSeq uint64, emit func(*models.ResponseChunk) error) error {
+	entries, err := d.client.XRangeN(d.ctx, streamKey(sessionID), fmt.Sprintf("(%d-0", lastSeq), "+", d.maxLen).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read mirrored chunk stream for session %s: %w", sessionID, err)
+	}
+
+	for _, entry := range entries {
+		chunk, err := decodeStreamChunk(sessionID, entry)
+		if err != nil {
+			return err
+		}
+		if err := emit(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeStreamChunk reconstructs a ResponseChunk from a Redis Stream entry
+// written by mirrorChunk.
+func decodeStreamChunk(sessionID string, entry redis.XMessage) (*models.ResponseChunk, error) {
+	chunk := &models.ResponseChunk{
+		SessionID: sessionID,
+		StreamID:  entry.ID,
+	}
+
+	if seq, _, ok := strings.Cut(entry.ID, "-"); ok {
+		parsed, err := strconv.ParseUint(seq, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode sequence for session %s: %w", sessionID, err)
+		}
+		chunk.Sequence = parsed
+	}
+	if v, ok := entry.Values["message_id"].(string); ok {
+		chunk.MessageID = v
+	}
+	if v, ok := entry.Values["chunk_type"].(string); ok {
+		chunk.ChunkType = v
+	}
+	if v, ok := entry.Values["chunk"].(string); ok {
+		chunk.Chunk = v
+	}
+	if v, ok := entry.Values["is_final"].(string); ok {
+		chunk.IsFinal = v == "1" || v == "true"
+	}
+	if v, ok := entry.Values["chunk_id"].(string); ok {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode chunk_id for session %s: %w", sessionID, err)
+		}
+		chunk.ChunkID = id
+	}
+	if v, ok := entry.Values["metadata"].(string); ok && v != "" && v != "null" {
+		if err := json.Unmarshal([]byte(v), &chunk.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode metadata for session %s: %w", sessionID, err)
+		}
+	}
+
+	return chunk, nil
+}
+
+// Close closes the underlying Redis client.
+func (d *DistributedManager) Close() error {
+	return d.client.Close()
+}
@@ -0,0 +1,88 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/rohitkeshwani07/chat/backend/internal/models"
+)
+
+// newTestDistributedManager builds a DistributedManager backed by addr,
+// simulating one pod's buffer layer. Each pod gets its own in-memory
+// Manager (chunks received by one pod are never in the other's memory),
+// but both share the same Redis Stream mirror.
+func newTestDistributedManager(t *testing.T, addr string) *DistributedManager {
+	t.Helper()
+
+	inner := NewManager(100, 1000, 0, 0, 0, nil, nil, nil)
+	dm, err := NewDistributedManager(inner, addr, "", 0, 0, nil)
+	if err != nil {
+		t.Fatalf("NewDistributedManager: %v", err)
+	}
+	return dm
+}
+
+// TestDistributedManagerReplayAcrossPods simulates a client that connects
+// to pod A, receives some chunks, then reconnects mid-stream to pod B
+// (e.g. after pod A restarts or a load balancer reroutes it). Pod B never
+// saw those chunks in its own in-memory buffer, so it can only resume the
+// client by replaying them out of the shared Redis Stream mirror.
+func TestDistributedManagerReplayAcrossPods(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	podA := newTestDistributedManager(t, mr.Addr())
+	podB := newTestDistributedManager(t, mr.Addr())
+
+	sessionID := "session-1"
+	messageID := "message-1"
+
+	for i := 0; i < 3; i++ {
+		chunk := &models.ResponseChunk{
+			SessionID: sessionID,
+			MessageID: messageID,
+			ChunkID:   i,
+			Chunk:     "chunk",
+			ChunkType: "content",
+		}
+		if _, _, err := podA.SendChunk(chunk); err != nil {
+			t.Fatalf("podA.SendChunk(%d): %v", i, err)
+		}
+	}
+
+	// Client reconnects to pod B with Last-Event-ID of the first chunk
+	// mirrored by pod A (sequence 1), so it should only be replayed
+	// sequences 2 and 3.
+	var replayed []*models.ResponseChunk
+	if err := podB.ReplaySince(sessionID, 1, func(chunk *models.ResponseChunk) error {
+		replayed = append(replayed, chunk)
+		return nil
+	}); err != nil {
+		t.Fatalf("podB.ReplaySince: %v", err)
+	}
+
+	if len(replayed) != 2 {
+		t.Fatalf("expected 2 replayed chunks, got %d", len(replayed))
+	}
+	for i, chunk := range replayed {
+		wantSeq := uint64(i + 2)
+		if chunk.Sequence != wantSeq {
+			t.Errorf("replayed[%d].Sequence = %d, want %d", i, chunk.Sequence, wantSeq)
+		}
+		if chunk.MessageID != messageID {
+			t.Errorf("replayed[%d].MessageID = %q, want %q", i, chunk.MessageID, messageID)
+		}
+	}
+
+	// A fresh reconnect to pod B with no cursor at all should replay
+	// everything pod A mirrored.
+	replayed = nil
+	if err := podB.ReplaySince(sessionID, 0, func(chunk *models.ResponseChunk) error {
+		replayed = append(replayed, chunk)
+		return nil
+	}); err != nil {
+		t.Fatalf("podB.ReplaySince from 0: %v", err)
+	}
+	if len(replayed) != 3 {
+		t.Fatalf("expected 3 replayed chunks from 0, got %d", len(replayed))
+	}
+}
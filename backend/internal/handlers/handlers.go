@@ -1,71 +1,124 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/rohitkeshwani07/chat/backend/internal/auth"
 	"github.com/rohitkeshwani07/chat/backend/internal/buffer"
+	"github.com/rohitkeshwani07/chat/backend/internal/logging"
+	"github.com/rohitkeshwani07/chat/backend/internal/messagebus"
+	"github.com/rohitkeshwani07/chat/backend/internal/metrics"
 	"github.com/rohitkeshwani07/chat/backend/internal/models"
-	natsClient "github.com/rohitkeshwani07/chat/backend/internal/nats"
 	"github.com/rohitkeshwani07/chat/backend/internal/registry"
 	"github.com/rohitkeshwani07/chat/backend/internal/sse"
+	"github.com/rohitkeshwani07/chat/backend/internal/storage"
+	"github.com/rohitkeshwani07/chat/backend/internal/tracing"
+	"github.com/rohitkeshwani07/chat/backend/internal/transport"
+	"github.com/rohitkeshwani07/chat/backend/internal/ws"
 )
 
 // Handler holds dependencies for HTTP handlers
 type Handler struct {
-	podID          string
-	nats           *natsClient.Client
-	registry       *registry.SessionRegistry
-	sseManager     *sse.Manager
-	bufferManager  *buffer.Manager
-	logger         *log.Logger
+	podID         string
+	bus           messagebus.Bus
+	registry      *registry.SessionRegistry
+	sseManager    *sse.Manager
+	bufferManager buffer.ChunkManager
+	store         storage.MessageStore // nil if persistence is disabled
+	logger        *slog.Logger
+	metrics       *metrics.Registry
+
+	// chatStarted tracks when HandleChat accepted a message, keyed by
+	// message ID, so HandleResponseChunk can report the chat_request_latency_seconds
+	// histogram once the message completes. An entry whose message never
+	// completes (e.g. the buffer stalls and is cleaned up) is never
+	// observed, but it's a single time.Time per in-flight message and is
+	// bounded by the same traffic the chunk buffer itself is already sized for.
+	chatStarted sync.Map
 }
 
-// New creates a new handler
+// New creates a new handler. bus may be backed by NATS or RabbitMQ; the
+// handler only relies on the messagebus.Bus interface. bufferManager may be
+// a plain *buffer.Manager or a *buffer.DistributedManager; the handler only
+// relies on the buffer.ChunkManager interface. store may be nil, in which
+// case chat history is neither persisted nor queryable.
 func New(
 	podID string,
-	natsClient *natsClient.Client,
+	bus messagebus.Bus,
 	registry *registry.SessionRegistry,
 	sseManager *sse.Manager,
-	bufferManager *buffer.Manager,
-	logger *log.Logger,
+	bufferManager buffer.ChunkManager,
+	store storage.MessageStore,
+	logger *slog.Logger,
+	metricsRegistry *metrics.Registry,
 ) *Handler {
 	return &Handler{
 		podID:         podID,
-		nats:          natsClient,
+		bus:           bus,
 		registry:      registry,
 		sseManager:    sseManager,
 		bufferManager: bufferManager,
+		store:         store,
 		logger:        logger,
+		metrics:       metricsRegistry,
 	}
 }
 
 // HandleChat handles POST /api/chat requests
 func (h *Handler) HandleChat(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.StartSpan(r.Context(), "HandleChat")
+	defer span.End()
+
 	if r.Method != http.MethodPost {
+		h.metrics.IncChatRequest("rejected")
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req models.ChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.metrics.IncChatRequest("rejected")
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
 	// Validate request
 	if req.SessionID == "" || req.Message == "" || req.UserID == "" {
+		h.metrics.IncChatRequest("rejected")
 		http.Error(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
 
-	// Generate message ID and correlation ID
+	// When auth is enabled, the body's user_id must agree with the token
+	// subject; otherwise a caller could act on another user's behalf just
+	// by putting a different user_id in the request.
+	if authUserID, ok := auth.UserIDFromContext(r.Context()); ok && authUserID != req.UserID {
+		h.metrics.IncChatRequest("rejected")
+		http.Error(w, "user_id does not match authenticated user", http.StatusForbidden)
+		return
+	}
+
+	// Generate message ID. The correlation ID reuses the request ID the
+	// logging middleware already stashed in the context (falling back to a
+	// fresh one if the handler is invoked without it, e.g. in a test) so
+	// every log line for this call -- including the async
+	// HandleResponseChunk delivery it triggers -- can be grepped by one ID.
 	messageID := uuid.New().String()
-	correlationID := uuid.New().String()
+	correlationID, ok := logging.RequestIDFromContext(r.Context())
+	if !ok {
+		correlationID = uuid.New().String()
+	}
+
+	h.chatStarted.Store(messageID, time.Now())
 
 	// Create workflow request
 	workflowReq := &models.WorkflowRequest{
@@ -76,6 +129,7 @@ func (h *Handler) HandleChat(w http.ResponseWriter, r *http.Request) {
 		Context:       req.Context,
 		Timestamp:     time.Now(),
 		CorrelationID: correlationID,
+		TraceParent:   tracing.Inject(ctx),
 	}
 
 	// Set AI provider and model if specified
@@ -87,9 +141,30 @@ func (h *Handler) HandleChat(w http.ResponseWriter, r *http.Request) {
 		workflowReq.Context["model"] = req.Model
 	}
 
-	// Publish to NATS
-	if err := h.nats.PublishWorkflowRequest(workflowReq); err != nil {
-		h.logger.Printf("Failed to publish workflow request: %v", err)
+	// Make sure the session row exists before any message referencing it is
+	// written; the message bus round trip below is not in the critical path
+	// of the user seeing a response, so a blocking upsert here is fine.
+	if h.store != nil {
+		now := time.Now()
+		session := &models.ChatSession{
+			SessionID:  req.SessionID,
+			UserID:     req.UserID,
+			AIProvider: req.AIProvider,
+			ModelName:  req.Model,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+			IsActive:   true,
+		}
+		if err := h.store.SaveSession(session); err != nil {
+			h.logger.Error("Failed to save session", "session_id", req.SessionID, "error", err)
+		}
+	}
+
+	// Publish the workflow request to the message bus
+	if err := h.bus.PublishWorkflowRequest(workflowReq); err != nil {
+		h.chatStarted.Delete(messageID)
+		h.metrics.IncChatRequest("rejected")
+		h.logger.Error("Failed to publish workflow request", "correlation_id", correlationID, "error", err)
 		http.Error(w, "Failed to process request", http.StatusInternalServerError)
 		return
 	}
@@ -107,7 +182,8 @@ func (h *Handler) HandleChat(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(response)
 
-	h.logger.Printf("Chat message accepted: session=%s, message=%s", req.SessionID, messageID)
+	h.metrics.IncChatRequest("accepted")
+	h.logger.Info("Chat message accepted", "session_id", req.SessionID, "message_id", messageID, "correlation_id", correlationID)
 }
 
 // HandleSSE handles GET /api/sse requests
@@ -131,10 +207,25 @@ func (h *Handler) HandleSSE(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// When auth is enabled, the query's user_id must agree with the token
+	// subject; otherwise a caller could subscribe to another user's
+	// connection just by putting a different user_id in the query string.
+	if authUserID, ok := auth.UserIDFromContext(r.Context()); ok && authUserID != userID {
+		http.Error(w, "user_id does not match authenticated user", http.StatusForbidden)
+		return
+	}
+
+	// Resume cursor: prefer the standard Last-Event-ID header, fall back to
+	// a query param for clients (e.g. EventSource polyfills) that can't set it.
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
 	// Create SSE connection
-	conn, err := h.sseManager.AddConnection(sessionID, userID, w, r)
+	conn, err := h.sseManager.AddConnection(sessionID, userID, w, r, lastEventID)
 	if err != nil {
-		h.logger.Printf("Failed to create SSE connection: %v", err)
+		h.logger.Error("Failed to create SSE connection", "session_id", sessionID, "error", err)
 		http.Error(w, "Failed to establish SSE connection", http.StatusInternalServerError)
 		return
 	}
@@ -152,11 +243,11 @@ func (h *Handler) HandleSSE(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.registry.RegisterConnection(activeConn); err != nil {
-		h.logger.Printf("Failed to register connection in registry: %v", err)
+		h.logger.Warn("Failed to register connection in registry", "conn_id", conn.ID, "error", err)
 		// Continue anyway - connection is still usable
 	}
 
-	h.logger.Printf("SSE connection established: %s for session %s", conn.ID, sessionID)
+	h.logger.Info("SSE connection established", "conn_id", conn.ID, "session_id", sessionID)
 
 	// Start heartbeat for this connection
 	heartbeatTicker := time.NewTicker(10 * time.Second)
@@ -177,43 +268,129 @@ func (h *Handler) HandleSSE(w http.ResponseWriter, r *http.Request) {
 
 		case <-heartbeatTicker.C:
 			// Send heartbeat and update registry
-			if err := h.registry.UpdateHeartbeat(h.podID, conn.ID); err != nil {
-				h.logger.Printf("Failed to update heartbeat: %v", err)
+			if err := h.registry.UpdateHeartbeat(conn.SessionID, h.podID, conn.ID); err != nil {
+				h.logger.Warn("Failed to update heartbeat", "conn_id", conn.ID, "error", err)
 			}
 		}
 	}
 }
 
-// cleanup handles connection cleanup
-func (h *Handler) cleanup(conn *sse.Connection) {
-	h.logger.Printf("Cleaning up SSE connection: %s", conn.ID)
+// cleanup handles connection cleanup for any transport
+func (h *Handler) cleanup(conn transport.Connection) {
+	h.logger.Info("Cleaning up connection", "conn_id", conn.ConnID())
 
-	// Remove from SSE manager
-	h.sseManager.RemoveConnection(conn.ID)
+	// Remove from the connection manager
+	h.sseManager.RemoveConnection(conn.ConnID())
 
 	// Deregister from session registry
-	if err := h.registry.DeregisterConnection(conn.SessionID, h.podID, conn.ID); err != nil {
-		h.logger.Printf("Failed to deregister connection: %v", err)
+	if err := h.registry.DeregisterConnection(conn.Session(), h.podID, conn.ConnID()); err != nil {
+		h.logger.Warn("Failed to deregister connection", "conn_id", conn.ConnID(), "error", err)
+	}
+}
+
+// HandleWS handles GET /api/ws requests, upgrading to WebSocket. It's the
+// bidirectional counterpart to HandleSSE: browsers behind proxies that
+// mangle SSE can use it instead, and it additionally accepts client->server
+// messages (see handleInbound) that SSE has no way to carry.
+func (h *Handler) HandleWS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		http.Error(w, "Missing session_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "Missing user_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	if authUserID, ok := auth.UserIDFromContext(r.Context()); ok && authUserID != userID {
+		http.Error(w, "user_id does not match authenticated user", http.StatusForbidden)
+		return
+	}
+
+	lastEventID := r.URL.Query().Get("last_event_id")
+
+	conn, err := ws.Upgrade(w, r, sessionID, userID, 0, 0, h.logger)
+	if err != nil {
+		// Upgrade already wrote the HTTP error response on failure.
+		h.logger.Error("Failed to upgrade WebSocket connection", "session_id", sessionID, "error", err)
+		return
+	}
+
+	h.sseManager.Register(conn, lastEventID)
+
+	activeConn := &models.ActiveConnection{
+		ConnectionID:  conn.ConnID(),
+		SessionID:     sessionID,
+		PodID:         h.podID,
+		UserID:        userID,
+		ConnectedAt:   conn.Opened(),
+		LastHeartbeat: time.Now(),
+		ClientIP:      conn.RemoteAddr(),
+		UserAgent:     conn.Agent(),
+	}
+
+	if err := h.registry.RegisterConnection(activeConn); err != nil {
+		h.logger.Warn("Failed to register connection in registry", "conn_id", conn.ConnID(), "error", err)
+		// Continue anyway - connection is still usable
+	}
+
+	h.logger.Info("WebSocket connection established", "conn_id", conn.ConnID(), "session_id", sessionID)
+
+	go conn.ReadPump(h.handleInbound, func() { h.cleanup(conn) })
+
+	<-conn.Closed()
+}
+
+// handleInbound processes a client->server message received over a
+// bidirectional transport (today, only WebSocket).
+func (h *Handler) handleInbound(conn transport.Connection, msg *transport.InboundMessage) error {
+	switch msg.Type {
+	case "cancel_message":
+		if msg.MessageID == "" {
+			return fmt.Errorf("cancel_message missing message_id")
+		}
+		return h.bus.PublishCancel(msg.MessageID)
+
+	case "typing":
+		return h.sseManager.SendToSession(conn.Session(), &models.SSEEvent{
+			Event: "typing",
+			Data: map[string]string{
+				"user_id": conn.User(),
+			},
+		})
+
+	case "heartbeat_ack":
+		return h.registry.UpdateHeartbeat(conn.Session(), h.podID, conn.ConnID())
+
+	default:
+		return fmt.Errorf("unknown inbound message type %q", msg.Type)
 	}
 }
 
 // HandleResponseChunk handles incoming response chunks from NATS
 func (h *Handler) HandleResponseChunk(chunk *models.ResponseChunk) error {
-	// Add chunk to buffer
-	if err := h.bufferManager.AddChunk(chunk); err != nil {
-		return fmt.Errorf("failed to buffer chunk: %w", err)
-	}
+	ctx := tracing.Extract(context.Background(), chunk.TraceParent)
+	_, span := tracing.StartSpan(ctx, "HandleResponseChunk")
+	defer span.End()
 
-	// Get next available chunks to send
-	chunksToSend, isComplete, err := h.bufferManager.GetNextChunks(chunk.MessageID)
+	// Buffer the chunk and get back any now-contiguous prefix to flush
+	chunksToSend, isComplete, err := h.bufferManager.SendChunk(chunk)
 	if err != nil {
-		return fmt.Errorf("failed to get next chunks: %w", err)
+		return err
 	}
 
 	// Send available chunks to client via SSE
 	for _, c := range chunksToSend {
 		if err := h.sseManager.SendChunk(chunk.SessionID, c); err != nil {
-			h.logger.Printf("Failed to send chunk to SSE: %v", err)
+			h.logger.Warn("Failed to send chunk to SSE", "session_id", chunk.SessionID, "correlation_id", chunk.CorrelationID, "error", err)
 			// Continue sending other chunks
 		}
 	}
@@ -225,9 +402,34 @@ func (h *Handler) HandleResponseChunk(chunk *models.ResponseChunk) error {
 			return fmt.Errorf("failed to finalize message: %w", err)
 		}
 
-		// TODO: Persist message to database
-		h.logger.Printf("Message complete: %s (%d bytes, %d tokens)",
-			message.MessageID, len(message.Content), message.TokenCount)
+		h.logger.Info("Message complete", "message_id", message.MessageID, "bytes", len(message.Content), "tokens", message.TokenCount, "correlation_id", chunk.CorrelationID)
+
+		if startedAt, ok := h.chatStarted.LoadAndDelete(message.MessageID); ok {
+			h.metrics.ObserveChatLatencySeconds(time.Since(startedAt.(time.Time)).Seconds())
+		}
+
+		if h.store != nil {
+			now := time.Now()
+			if err := h.store.SaveMessage(&models.Message{
+				MessageID:  message.MessageID,
+				SessionID:  chunk.SessionID,
+				Role:       "assistant",
+				Content:    message.Content,
+				CreatedAt:  now,
+				TokenCount: message.TokenCount,
+			}); err != nil {
+				h.logger.Error("Failed to save message", "message_id", message.MessageID, "error", err)
+			}
+			if session, err := h.store.GetSession(chunk.SessionID); err != nil {
+				h.logger.Error("Failed to load session for last-message update", "session_id", chunk.SessionID, "error", err)
+			} else {
+				session.LastMessageAt = &now
+				session.UpdatedAt = now
+				if err := h.store.SaveSession(session); err != nil {
+					h.logger.Error("Failed to update session", "session_id", chunk.SessionID, "error", err)
+				}
+			}
+		}
 
 		// Send completion event
 		h.sseManager.SendToSession(chunk.SessionID, &models.SSEEvent{
@@ -242,18 +444,224 @@ func (h *Handler) HandleResponseChunk(chunk *models.ResponseChunk) error {
 	return nil
 }
 
+// HandleSessions handles GET and POST /api/sessions. GET lists a user's
+// sessions most-recent-first; POST creates (or updates) one. Both return
+// 503 if persistence is disabled.
+func (h *Handler) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	if h.store == nil {
+		http.Error(w, "Chat history is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// When auth is enabled, list the authenticated caller's own
+		// sessions rather than trusting a query-string user_id: this
+		// endpoint returns full chat history, so letting the query string
+		// pick whose history to return would let any caller read anyone
+		// else's conversations.
+		userID, ok := auth.UserIDFromContext(r.Context())
+		if !ok {
+			userID = r.URL.Query().Get("user_id")
+		}
+		if userID == "" {
+			http.Error(w, "Missing user_id parameter", http.StatusBadRequest)
+			return
+		}
+
+		limit := 50
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+				limit = parsed
+			}
+		}
+
+		var before time.Time
+		if v := r.URL.Query().Get("before"); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "Invalid before parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+			before = parsed
+		}
+
+		sessions, err := h.store.ListSessions(userID, limit, before)
+		if err != nil {
+			h.logger.Error("Failed to list sessions", "user_id", userID, "error", err)
+			http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+
+	case http.MethodPost:
+		var session models.ChatSession
+		if err := json.NewDecoder(r.Body).Decode(&session); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if session.UserID == "" {
+			http.Error(w, "Missing required field: user_id", http.StatusBadRequest)
+			return
+		}
+
+		// When auth is enabled, the body's user_id must agree with the
+		// token subject; otherwise a caller could create or update a
+		// session under another user's identity just by putting a
+		// different user_id in the request.
+		if authUserID, ok := auth.UserIDFromContext(r.Context()); ok && authUserID != session.UserID {
+			http.Error(w, "user_id does not match authenticated user", http.StatusForbidden)
+			return
+		}
+
+		if session.SessionID == "" {
+			session.SessionID = uuid.New().String()
+		}
+
+		now := time.Now()
+		session.CreatedAt = now
+		session.UpdatedAt = now
+		session.IsActive = true
+
+		if err := h.store.SaveSession(&session); err != nil {
+			h.logger.Error("Failed to save session", "session_id", session.SessionID, "error", err)
+			http.Error(w, "Failed to save session", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(session)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleSessionMessages handles GET /api/sessions/{id}/messages, listing a
+// session's messages most-recent-first. Returns 503 if persistence is
+// disabled.
+func (h *Handler) HandleSessionMessages(w http.ResponseWriter, r *http.Request) {
+	if h.store == nil {
+		http.Error(w, "Chat history is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/sessions/"), "/messages")
+	if sessionID == "" || sessionID == r.URL.Path {
+		http.Error(w, "Missing session ID in path", http.StatusBadRequest)
+		return
+	}
+
+	// When auth is enabled, only the session's owner can read its
+	// messages: the path only carries a session ID, with no query-string
+	// user_id to even check, so without this the endpoint would return
+	// any authenticated caller's full message history for any session ID
+	// they guess.
+	if authUserID, ok := auth.UserIDFromContext(r.Context()); ok {
+		session, err := h.store.GetSession(sessionID)
+		if err != nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if session.UserID != authUserID {
+			http.Error(w, "user_id does not match authenticated user", http.StatusForbidden)
+			return
+		}
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	var before time.Time
+	if v := r.URL.Query().Get("before"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid before parameter, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+		before = parsed
+	}
+
+	messages, err := h.store.ListMessages(sessionID, limit, before)
+	if err != nil {
+		h.logger.Error("Failed to list messages", "session_id", sessionID, "error", err)
+		http.Error(w, "Failed to list messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
 // HandleHealth handles GET /health requests
 func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	health := map[string]interface{}{
-		"status":              "healthy",
-		"pod_id":              h.podID,
-		"timestamp":           time.Now().Unix(),
-		"active_connections":  h.sseManager.GetConnectionCount(),
-		"active_sessions":     h.sseManager.GetSessionCount(),
-		"active_buffers":      h.bufferManager.GetBufferCount(),
-		"nats_connected":      h.nats.IsConnected(),
+		"status":                "healthy",
+		"pod_id":                h.podID,
+		"timestamp":             time.Now().Unix(),
+		"active_connections":    h.sseManager.GetConnectionCount(),
+		"active_sessions":       h.sseManager.GetSessionCount(),
+		"active_buffers":        h.bufferManager.GetBufferCount(),
+		"dropped_connections":   h.sseManager.GetDroppedConnectionCount(),
+		"message_bus_connected": h.bus.IsConnected(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(health)
 }
+
+// HandleHealthz handles GET /healthz, a standard k8s-probe-style check:
+// it reports a terse pass/fail per dependency and a 503 if any are down,
+// as opposed to HandleHealth's richer always-200 status snapshot.
+func (h *Handler) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]bool{
+		"message_bus": h.bus.IsConnected(),
+	}
+
+	if err := h.registry.Ping(); err != nil {
+		checks["redis"] = false
+		h.logger.Warn("Healthz Redis ping failed", "error", err)
+	} else {
+		checks["redis"] = true
+	}
+
+	if pinger, ok := h.store.(storage.Pinger); ok {
+		if err := pinger.Ping(); err != nil {
+			checks["database"] = false
+			h.logger.Warn("Healthz database ping failed", "error", err)
+		} else {
+			checks["database"] = true
+		}
+	}
+
+	healthy := true
+	for _, ok := range checks {
+		if !ok {
+			healthy = false
+			break
+		}
+	}
+
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"healthy": healthy,
+		"checks":  checks,
+	})
+}
@@ -0,0 +1,77 @@
+// Package logging provides the structured, leveled logger used across the
+// service, plus the request-ID middleware that lets every log line from a
+// single /api/chat (or /api/sse, /api/ws) call -- including the async
+// HandleResponseChunk delivery it eventually triggers -- be grepped by one
+// correlation ID.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the header a caller may supply to propagate its own
+// request ID; Middleware generates one when it's absent.
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// New builds the process-wide logger. format selects the handler: "console"
+// for human-readable text, anything else (including "") for JSON. level is
+// parsed via slog.Level.UnmarshalText ("debug", "info", "warn", "error",
+// case-insensitive); an unrecognized value falls back to info. component and
+// podID are attached to every line this logger (and its children) emit.
+func New(format, level, component, podID string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	if format == "console" {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+
+	return slog.New(handler).With("component", component, "pod_id", podID)
+}
+
+// WithRequestID returns a copy of ctx carrying requestID, retrievable later
+// via RequestIDFromContext.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext returns the request ID Middleware stashed in ctx, if
+// any.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey).(string)
+	return id, ok
+}
+
+// Middleware generates a request ID (or propagates one supplied via
+// RequestIDHeader), stashes it in the request context, and echoes it back on
+// the response so a client and its downstream logs can be correlated by the
+// same value.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = uuid.New().String()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		r = r.WithContext(WithRequestID(r.Context(), requestID))
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+// Package messagebus abstracts the transport used to publish workflow
+// requests and receive response chunks, so the rest of the service
+// doesn't need to know whether it's running against NATS or RabbitMQ.
+package messagebus
+
+import "github.com/rohitkeshwani07/chat/backend/internal/models"
+
+// ResponseHandler handles an incoming response chunk. A non-nil error is
+// logged by the caller but never stops delivery of subsequent messages.
+type ResponseHandler func(*models.ResponseChunk) error
+
+// Bus is implemented by nats.Client and rabbitmq.Client. Both backends
+// support the same operations the rest of the service relies on; anything
+// backend-specific (NATS JetStream replay, direct pod routing) lives only
+// on the concrete type and is wired up separately.
+type Bus interface {
+	// PublishWorkflowRequest publishes a workflow execution request.
+	PublishWorkflowRequest(req *models.WorkflowRequest) error
+
+	// SubscribeToResponses subscribes to response chunks addressed to this pod.
+	SubscribeToResponses(handler ResponseHandler) error
+
+	// SubscribeToBroadcast subscribes to response chunks broadcast to every pod.
+	SubscribeToBroadcast(handler ResponseHandler) error
+
+	// PublishCancel tells the workflow service to stop generating the
+	// response for messageID, e.g. because a client cancelled mid-stream.
+	PublishCancel(messageID string) error
+
+	// RequestResend asks the workflow service to re-publish specific chunks
+	// of messageID's response, e.g. because a buffer's gap has persisted
+	// past MissingChunkTimeout. Implements buffer.ChunkRequester.
+	RequestResend(messageID string, chunkIDs []int) error
+
+	// IsConnected reports whether the bus currently has a usable connection.
+	IsConnected() bool
+
+	// Drain gracefully finishes in-flight work before Close is called.
+	Drain() error
+
+	// Close releases the underlying connection.
+	Close()
+}
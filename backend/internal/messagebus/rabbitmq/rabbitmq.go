@@ -0,0 +1,268 @@
+// Package rabbitmq implements messagebus.Bus on top of RabbitMQ, for
+// operators who already run RabbitMQ in production and don't want to
+// introduce NATS just for this service.
+package rabbitmq
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rohitkeshwani07/chat/backend/internal/messagebus"
+	"github.com/rohitkeshwani07/chat/backend/internal/metrics"
+	"github.com/rohitkeshwani07/chat/backend/internal/models"
+)
+
+const (
+	// workflowExchange is a topic exchange; workflow requests are published
+	// with routing key "workflow.request.<session_id>".
+	workflowExchange = "chat.workflow.requests"
+
+	// responseExchange is a topic exchange; each pod declares its own queue
+	// bound to "response.<pod_id>.*" so it only receives chunks addressed
+	// to it.
+	responseExchange = "chat.responses"
+
+	// broadcastExchange is a fanout exchange: every bound queue gets every
+	// broadcast response chunk, mirroring NATS's broadcast subject.
+	broadcastExchange = "chat.broadcast"
+)
+
+// Client wraps a RabbitMQ connection and channel, implementing messagebus.Bus.
+type Client struct {
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	podID   string
+	logger  *slog.Logger
+	metrics *metrics.Registry
+}
+
+// New connects to RabbitMQ and declares the exchanges this service needs.
+// metricsRegistry may be nil to skip metrics collection.
+func New(url, podID string, logger *slog.Logger, metricsRegistry *metrics.Registry) (*Client, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	if err := declareTopology(ch); err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare exchanges: %w", err)
+	}
+
+	return &Client{
+		conn:    conn,
+		ch:      ch,
+		podID:   podID,
+		logger:  logger,
+		metrics: metricsRegistry,
+	}, nil
+}
+
+func declareTopology(ch *amqp.Channel) error {
+	if err := ch.ExchangeDeclare(workflowExchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare workflow exchange: %w", err)
+	}
+	if err := ch.ExchangeDeclare(responseExchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare response exchange: %w", err)
+	}
+	if err := ch.ExchangeDeclare(broadcastExchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare broadcast exchange: %w", err)
+	}
+	return nil
+}
+
+func workflowRoutingKey(sessionID string) string {
+	return fmt.Sprintf("workflow.request.%s", sessionID)
+}
+
+func cancelRoutingKey(messageID string) string {
+	return fmt.Sprintf("workflow.cancel.%s", messageID)
+}
+
+func responseRoutingKey(podID string) string {
+	return fmt.Sprintf("response.%s.chunk", podID)
+}
+
+func resendRoutingKey(messageID string) string {
+	return fmt.Sprintf("workflow.resend.%s", messageID)
+}
+
+// PublishWorkflowRequest publishes a workflow execution request to the topic
+// exchange, routed by session ID.
+func (c *Client) PublishWorkflowRequest(req *models.WorkflowRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow request: %w", err)
+	}
+
+	err = c.ch.Publish(workflowExchange, workflowRoutingKey(req.SessionID), false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        data,
+	})
+	if err != nil {
+		c.metrics.IncRabbitMQPublishError()
+		return fmt.Errorf("failed to publish workflow request: %w", err)
+	}
+
+	if c.logger != nil {
+		c.logger.Info("Published workflow request", "exchange", workflowExchange, "message_id", req.MessageID, "correlation_id", req.CorrelationID)
+	}
+
+	return nil
+}
+
+// PublishCancel tells the workflow service to stop generating the response
+// for messageID, routed on the same exchange as the workflow request that
+// produced it.
+func (c *Client) PublishCancel(messageID string) error {
+	err := c.ch.Publish(workflowExchange, cancelRoutingKey(messageID), false, false, amqp.Publishing{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		c.metrics.IncRabbitMQPublishError()
+		return fmt.Errorf("failed to publish cancel for message %s: %w", messageID, err)
+	}
+
+	if c.logger != nil {
+		c.logger.Info("Published cancel", "routing_key", cancelRoutingKey(messageID), "message_id", messageID)
+	}
+
+	return nil
+}
+
+// RequestResend asks the workflow service to re-publish chunkIDs of
+// messageID's response, routed on the same exchange as the workflow request
+// that produced it. Implements buffer.ChunkRequester.
+func (c *Client) RequestResend(messageID string, chunkIDs []int) error {
+	data, err := json.Marshal(&models.ResendRequest{MessageID: messageID, ChunkIDs: chunkIDs})
+	if err != nil {
+		return fmt.Errorf("failed to marshal resend request for message %s: %w", messageID, err)
+	}
+
+	err = c.ch.Publish(workflowExchange, resendRoutingKey(messageID), false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        data,
+	})
+	if err != nil {
+		c.metrics.IncRabbitMQPublishError()
+		return fmt.Errorf("failed to publish resend request for message %s: %w", messageID, err)
+	}
+
+	if c.logger != nil {
+		c.logger.Info("Published resend request", "routing_key", resendRoutingKey(messageID), "message_id", messageID, "chunk_ids", chunkIDs)
+	}
+
+	return nil
+}
+
+// SubscribeToResponses declares a per-pod queue bound to this pod's routing
+// key on the response exchange and consumes from it.
+func (c *Client) SubscribeToResponses(handler messagebus.ResponseHandler) error {
+	queueName := fmt.Sprintf("chat.responses.%s", c.podID)
+
+	queue, err := c.ch.QueueDeclare(queueName, true, true, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare response queue: %w", err)
+	}
+
+	if err := c.ch.QueueBind(queue.Name, fmt.Sprintf("response.%s.*", c.podID), responseExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind response queue: %w", err)
+	}
+
+	return c.consume(queue.Name, handler)
+}
+
+// SubscribeToBroadcast declares an exclusive queue bound to the fanout
+// broadcast exchange and consumes from it.
+func (c *Client) SubscribeToBroadcast(handler messagebus.ResponseHandler) error {
+	queue, err := c.ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare broadcast queue: %w", err)
+	}
+
+	if err := c.ch.QueueBind(queue.Name, "", broadcastExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind broadcast queue: %w", err)
+	}
+
+	return c.consume(queue.Name, handler)
+}
+
+func (c *Client) consume(queueName string, handler messagebus.ResponseHandler) error {
+	deliveries, err := c.ch.Consume(queueName, "", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to consume from queue %s: %w", queueName, err)
+	}
+
+	go func() {
+		for delivery := range deliveries {
+			var chunk models.ResponseChunk
+			if err := json.Unmarshal(delivery.Body, &chunk); err != nil {
+				if c.logger != nil {
+					c.logger.Error("Failed to unmarshal response chunk", "queue", queueName, "error", err)
+				}
+				c.metrics.IncRabbitMQSubscribeError()
+				continue
+			}
+
+			if err := handler(&chunk); err != nil && c.logger != nil {
+				c.logger.Error("Failed to handle response chunk", "queue", queueName, "correlation_id", chunk.CorrelationID, "error", err)
+			}
+		}
+	}()
+
+	if c.logger != nil {
+		c.logger.Info("Subscribed", "queue", queueName)
+	}
+
+	return nil
+}
+
+// PublishResponseChunk publishes a response chunk directly to podID's
+// routing key on the response exchange. Unlike nats.Client, this backend has
+// no presence-based PodLookup: the caller supplies podID.
+func (c *Client) PublishResponseChunk(podID string, chunk *models.ResponseChunk) error {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response chunk: %w", err)
+	}
+
+	if err := c.ch.Publish(responseExchange, responseRoutingKey(podID), false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        data,
+	}); err != nil {
+		c.metrics.IncRabbitMQPublishError()
+		return fmt.Errorf("failed to publish response chunk: %w", err)
+	}
+
+	return nil
+}
+
+// IsConnected reports whether the underlying connection is open.
+func (c *Client) IsConnected() bool {
+	return c.conn != nil && !c.conn.IsClosed()
+}
+
+// Drain is a no-op for RabbitMQ: unlike NATS there's no separate drain
+// step, closing the channel and connection is enough.
+func (c *Client) Drain() error {
+	return nil
+}
+
+// Close closes the channel and connection.
+func (c *Client) Close() {
+	if c.ch != nil {
+		c.ch.Close()
+	}
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
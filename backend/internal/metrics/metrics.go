@@ -0,0 +1,326 @@
+// Package metrics provides a single Prometheus registry shared across the
+// SSE, NATS, and buffer subsystems. Every collector method tolerates a nil
+// *Registry so callers (and tests) can pass one in only when they actually
+// want metrics collected.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry bundles every collector this service exports. Connection counts
+// are labeled by pod only, not session: a per-session label would give
+// Prometheus one time series per chat session, which is unbounded
+// cardinality for no real operational benefit.
+type Registry struct {
+	registry *prometheus.Registry
+
+	activeConnections *prometheus.GaugeVec
+	activeSessions    *prometheus.GaugeVec
+	eventsSent        *prometheus.CounterVec
+	eventsDropped     *prometheus.CounterVec
+	writeLatency      prometheus.Histogram
+
+	natsPublishErrors   prometheus.Counter
+	natsSubscribeErrors prometheus.Counter
+	natsReconnects      prometheus.Counter
+
+	rabbitmqPublishErrors   prometheus.Counter
+	rabbitmqSubscribeErrors prometheus.Counter
+
+	bufferedChunks        prometheus.Gauge
+	bufferGaps            prometheus.Counter
+	bufferEvictions       prometheus.Counter
+	bufferStalls          prometheus.Counter
+	bufferMirrorErrors    prometheus.Counter
+	bufferResendRequested prometheus.Counter
+	bufferFailures        prometheus.Counter
+
+	chatRequests  *prometheus.CounterVec
+	chatLatency   prometheus.Histogram
+	sseSendErrors prometheus.Counter
+}
+
+// NewRegistry creates a Registry and registers all collectors against a
+// fresh Prometheus registry.
+func NewRegistry() *Registry {
+	r := &Registry{registry: prometheus.NewRegistry()}
+
+	r.activeConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "chat_sse_active_connections",
+		Help: "Number of active SSE connections on this pod.",
+	}, []string{"pod_id"})
+
+	r.activeSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "chat_sse_active_sessions",
+		Help: "Number of sessions with at least one active SSE connection on this pod.",
+	}, []string{"pod_id"})
+
+	r.eventsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_sse_events_sent_total",
+		Help: "SSE events successfully written to a client, by event type.",
+	}, []string{"event_type"})
+
+	r.eventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_sse_events_dropped_total",
+		Help: "SSE events dropped without delivery, by reason.",
+	}, []string{"reason"})
+
+	r.writeLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_sse_write_latency_seconds",
+		Help:    "Latency of a single SSE write to a client socket.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	r.natsPublishErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_nats_publish_errors_total",
+		Help: "NATS publish calls that returned an error.",
+	})
+
+	r.natsSubscribeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_nats_subscribe_errors_total",
+		Help: "Errors decoding or handling a NATS subscription message.",
+	})
+
+	r.natsReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_nats_reconnects_total",
+		Help: "Number of times the NATS connection has reconnected.",
+	})
+
+	r.rabbitmqPublishErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_rabbitmq_publish_errors_total",
+		Help: "RabbitMQ publish calls that returned an error.",
+	})
+
+	r.rabbitmqSubscribeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_rabbitmq_subscribe_errors_total",
+		Help: "Errors decoding or handling a RabbitMQ delivery.",
+	})
+
+	r.bufferedChunks = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_buffer_active_buffers",
+		Help: "Number of chunk buffers currently held in memory on this pod.",
+	})
+
+	r.bufferGaps = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_buffer_gap_timeouts_total",
+		Help: "Number of chunk buffer gaps that persisted past MissingChunkTimeout.",
+	})
+
+	r.bufferEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_buffer_evictions_total",
+		Help: "Number of chunk buffers evicted by the LRU policy before completion.",
+	})
+
+	r.bufferStalls = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_buffer_stalls_total",
+		Help: "Number of chunk buffers removed by cleanup for going stale (no chunk arrived within MaxBufferAge) without ever completing.",
+	})
+
+	r.bufferMirrorErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_buffer_mirror_errors_total",
+		Help: "Chunks that failed to mirror to the distributed buffer's Redis Stream.",
+	})
+
+	r.bufferResendRequested = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_buffer_resend_requests_total",
+		Help: "Chunk resend (NACK) requests issued by cleanup for buffers with a persistent gap.",
+	})
+
+	r.bufferFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_buffer_failures_total",
+		Help: "Chunk buffers given up on after maxResendAttempts unsuccessful resend requests.",
+	})
+
+	r.chatRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_requests_total",
+		Help: "Chat POST requests handled, by outcome.",
+	}, []string{"status"})
+
+	r.chatLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_request_latency_seconds",
+		Help:    "End-to-end latency from a chat POST being accepted to its final response chunk being sent.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	r.sseSendErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_sse_send_errors_total",
+		Help: "SSE writes to a client socket that failed and caused the connection to be dropped.",
+	})
+
+	r.registry.MustRegister(
+		r.activeConnections,
+		r.activeSessions,
+		r.eventsSent,
+		r.eventsDropped,
+		r.writeLatency,
+		r.natsPublishErrors,
+		r.natsSubscribeErrors,
+		r.natsReconnects,
+		r.rabbitmqPublishErrors,
+		r.rabbitmqSubscribeErrors,
+		r.bufferedChunks,
+		r.bufferGaps,
+		r.bufferEvictions,
+		r.bufferStalls,
+		r.bufferMirrorErrors,
+		r.bufferResendRequested,
+		r.bufferFailures,
+		r.chatRequests,
+		r.chatLatency,
+		r.sseSendErrors,
+	)
+
+	return r
+}
+
+// Handler returns the /metrics HTTP handler for this registry.
+func (r *Registry) Handler() http.Handler {
+	if r == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{})
+}
+
+func (r *Registry) SetActiveConnections(podID string, count int) {
+	if r == nil {
+		return
+	}
+	r.activeConnections.WithLabelValues(podID).Set(float64(count))
+}
+
+func (r *Registry) SetActiveSessions(podID string, count int) {
+	if r == nil {
+		return
+	}
+	r.activeSessions.WithLabelValues(podID).Set(float64(count))
+}
+
+func (r *Registry) IncEventsSent(eventType string) {
+	if r == nil {
+		return
+	}
+	r.eventsSent.WithLabelValues(eventType).Inc()
+}
+
+func (r *Registry) IncEventsDropped(reason string) {
+	if r == nil {
+		return
+	}
+	r.eventsDropped.WithLabelValues(reason).Inc()
+}
+
+func (r *Registry) ObserveWriteLatencySeconds(seconds float64) {
+	if r == nil {
+		return
+	}
+	r.writeLatency.Observe(seconds)
+}
+
+func (r *Registry) IncNATSPublishError() {
+	if r == nil {
+		return
+	}
+	r.natsPublishErrors.Inc()
+}
+
+func (r *Registry) IncNATSSubscribeError() {
+	if r == nil {
+		return
+	}
+	r.natsSubscribeErrors.Inc()
+}
+
+func (r *Registry) IncNATSReconnect() {
+	if r == nil {
+		return
+	}
+	r.natsReconnects.Inc()
+}
+
+func (r *Registry) IncRabbitMQPublishError() {
+	if r == nil {
+		return
+	}
+	r.rabbitmqPublishErrors.Inc()
+}
+
+func (r *Registry) IncRabbitMQSubscribeError() {
+	if r == nil {
+		return
+	}
+	r.rabbitmqSubscribeErrors.Inc()
+}
+
+func (r *Registry) SetBufferedChunks(count int) {
+	if r == nil {
+		return
+	}
+	r.bufferedChunks.Set(float64(count))
+}
+
+func (r *Registry) IncBufferGapTimeout() {
+	if r == nil {
+		return
+	}
+	r.bufferGaps.Inc()
+}
+
+func (r *Registry) IncBufferEviction() {
+	if r == nil {
+		return
+	}
+	r.bufferEvictions.Inc()
+}
+
+func (r *Registry) IncBufferStall() {
+	if r == nil {
+		return
+	}
+	r.bufferStalls.Inc()
+}
+
+func (r *Registry) IncBufferMirrorError() {
+	if r == nil {
+		return
+	}
+	r.bufferMirrorErrors.Inc()
+}
+
+func (r *Registry) IncBufferResendRequested() {
+	if r == nil {
+		return
+	}
+	r.bufferResendRequested.Inc()
+}
+
+func (r *Registry) IncBufferFailure() {
+	if r == nil {
+		return
+	}
+	r.bufferFailures.Inc()
+}
+
+func (r *Registry) IncChatRequest(status string) {
+	if r == nil {
+		return
+	}
+	r.chatRequests.WithLabelValues(status).Inc()
+}
+
+func (r *Registry) ObserveChatLatencySeconds(seconds float64) {
+	if r == nil {
+		return
+	}
+	r.chatLatency.Observe(seconds)
+}
+
+func (r *Registry) IncSSESendError() {
+	if r == nil {
+		return
+	}
+	r.sseSendErrors.Inc()
+}
@@ -59,6 +59,18 @@ type WorkflowRequest struct {
 	Context       map[string]interface{} `json:"context"`
 	Timestamp     time.Time              `json:"timestamp"`
 	CorrelationID string                 `json:"correlation_id"`
+	// TraceParent is the W3C traceparent for the OpenTelemetry span started
+	// around the originating HTTP request, so the workflow service's
+	// response (see ResponseChunk.TraceParent) can resume the same trace.
+	TraceParent string `json:"trace_parent,omitempty"`
+}
+
+// ResendRequest asks the workflow service to re-publish specific chunks of
+// messageID's response, published by buffer.ChunkRequester implementations
+// when a buffer's gap has persisted past MissingChunkTimeout.
+type ResendRequest struct {
+	MessageID string `json:"message_id"`
+	ChunkIDs  []int  `json:"chunk_ids"`
 }
 
 // ResponseChunk represents a chunk of response from the workflow service
@@ -73,6 +85,21 @@ type ResponseChunk struct {
 	Error         *ErrorInfo             `json:"error,omitempty"`
 	Timestamp     time.Time              `json:"timestamp"`
 	CorrelationID string                 `json:"correlation_id"`
+	// TraceParent echoes WorkflowRequest.TraceParent back so HandleResponseChunk
+	// can resume the trace the HTTP request started, instead of each async
+	// chunk delivery appearing as an unrelated span.
+	TraceParent string `json:"trace_parent,omitempty"`
+	// Sequence is the monotonic sequence number assigned when the chunk was
+	// mirrored for replay: the JetStream stream sequence (nats.Client), or
+	// the session's Redis counter when buffer.DistributedManager mirrors it
+	// afterwards. It doubles as the SSE `id:` field so clients can resume via
+	// Last-Event-ID.
+	Sequence uint64 `json:"sequence,omitempty"`
+	// StreamID is the Redis Stream entry ID assigned when the chunk was
+	// mirrored by buffer.DistributedManager. Set only on chunks decoded from
+	// a replay (see DistributedManager.ReplaySince); never marshaled onto
+	// the wire.
+	StreamID string `json:"-"`
 }
 
 // ErrorInfo represents error information in a response chunk
@@ -109,9 +136,11 @@ type SSEEvent struct {
 
 // BufferState represents the current state of a chunk buffer
 type BufferState struct {
-	TotalExpected int   `json:"total_expected"`
-	TotalReceived int   `json:"total_received"`
-	TotalSent     int   `json:"total_sent"`
-	MissingChunks []int `json:"missing_chunks"`
-	IsComplete    bool  `json:"is_complete"`
+	TotalExpected int    `json:"total_expected"`
+	TotalReceived int    `json:"total_received"`
+	TotalSent     int    `json:"total_sent"`
+	MissingChunks []int  `json:"missing_chunks"`
+	IsComplete    bool   `json:"is_complete"`
+	Failed        bool   `json:"failed"`
+	FailureReason string `json:"failure_reason,omitempty"`
 }
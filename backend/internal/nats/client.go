@@ -3,37 +3,86 @@ package nats
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/rohitkeshwani07/chat/backend/internal/messagebus"
+	"github.com/rohitkeshwani07/chat/backend/internal/metrics"
 	"github.com/rohitkeshwani07/chat/backend/internal/models"
 )
 
+// chunkStreamName is the JetStream stream that durably mirrors response
+// chunks so reconnecting SSE clients can replay from a Last-Event-ID.
+const chunkStreamName = "CHAT_CHUNKS"
+
+// PodLookup resolves the pod(s) currently serving a session, so a chunk can
+// be published directly to its owner instead of broadcast to every pod.
+// Implemented by presence.RedisPresence.
+type PodLookup interface {
+	LookupPods(sessionID string) ([]string, error)
+}
+
+// SessionPodLookup resolves which pod(s) currently own a session's SSE
+// connections via the SessionRegistry's connection-tracking keys.
+// Implemented by registry.SessionRegistry. Unlike PodLookup (queried by the
+// workflow service per response chunk via PublishResponseChunk), this is
+// queried once per outgoing workflow request, so the workflow service can
+// skip its own lookup entirely for the common case.
+type SessionPodLookup interface {
+	LookupSessionPods(sessionID string) ([]string, error)
+}
+
+// ownerPodsHeader carries the pod IDs SessionPodLookup resolved for a
+// workflow request, comma-separated. A workflow service that echoes this
+// header back when publishing its response can skip the equivalent lookup
+// on its own side and publish directly instead of broadcasting.
+const ownerPodsHeader = "X-Owning-Pods"
+
 // Client wraps the NATS connection
 type Client struct {
-	conn   *nats.Conn
-	podID  string
-	logger *log.Logger
+	conn        *nats.Conn
+	js          nats.JetStreamContext
+	podID       string
+	podLookup   PodLookup
+	sessionPods SessionPodLookup
+	logger      *slog.Logger
+	metrics     *metrics.Registry
 }
 
-// ResponseHandler is a function that handles incoming response chunks
-type ResponseHandler func(*models.ResponseChunk) error
+// SetPodLookup wires a PodLookup so PublishResponseChunk can route directly
+// to the pod(s) owning a session instead of always broadcasting.
+func (c *Client) SetPodLookup(lookup PodLookup) {
+	c.podLookup = lookup
+}
 
-// New creates a new NATS client
-func New(url string, podID string, maxReconnects int, reconnectWait time.Duration, logger *log.Logger) (*Client, error) {
+// SetSessionPodLookup wires a SessionPodLookup so PublishWorkflowRequest can
+// tag outgoing requests with the pod(s) already known to own the session.
+func (c *Client) SetSessionPodLookup(lookup SessionPodLookup) {
+	c.sessionPods = lookup
+}
+
+// ResponseHandler is a function that handles incoming response chunks. It's
+// an alias for messagebus.ResponseHandler (rather than a distinct type) so
+// that *Client satisfies messagebus.Bus.
+type ResponseHandler = messagebus.ResponseHandler
+
+// New creates a new NATS client. metricsRegistry may be nil to skip metrics
+// collection entirely.
+func New(url string, podID string, maxReconnects int, reconnectWait time.Duration, logger *slog.Logger, metricsRegistry *metrics.Registry) (*Client, error) {
 	opts := []nats.Option{
 		nats.MaxReconnects(maxReconnects),
 		nats.ReconnectWait(reconnectWait),
 		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
 			if logger != nil {
-				logger.Printf("NATS disconnected: %v", err)
+				logger.Error("NATS disconnected", "error", err)
 			}
 		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
 			if logger != nil {
-				logger.Printf("NATS reconnected to %s", nc.ConnectedUrl())
+				logger.Info("NATS reconnected", "url", nc.ConnectedUrl())
 			}
+			metricsRegistry.IncNATSReconnect()
 		}),
 	}
 
@@ -42,14 +91,60 @@ func New(url string, podID string, maxReconnects int, reconnectWait time.Duratio
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
 	}
 
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+
+	if err := ensureChunkStream(js); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to ensure chunk stream: %w", err)
+	}
+
 	return &Client{
-		conn:   nc,
-		podID:  podID,
-		logger: logger,
+		conn:    nc,
+		js:      js,
+		podID:   podID,
+		logger:  logger,
+		metrics: metricsRegistry,
 	}, nil
 }
 
-// PublishWorkflowRequest publishes a workflow execution request
+// ensureChunkStream creates the chunk-replay stream if it doesn't already exist.
+func ensureChunkStream(js nats.JetStreamContext) error {
+	_, err := js.StreamInfo(chunkStreamName)
+	if err == nil {
+		return nil
+	}
+	if err != nats.ErrStreamNotFound {
+		return err
+	}
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:      chunkStreamName,
+		Subjects:  []string{chunkSubjectWildcard()},
+		Retention: nats.LimitsPolicy,
+		MaxAge:    24 * time.Hour,
+		Storage:   nats.FileStorage,
+	})
+	return err
+}
+
+// chunkSubject returns the per-session JetStream subject a chunk is mirrored to.
+func chunkSubject(sessionID string) string {
+	return fmt.Sprintf("chat.chunks.%s", sessionID)
+}
+
+// chunkSubjectWildcard returns the subject pattern the stream is bound to.
+func chunkSubjectWildcard() string {
+	return "chat.chunks.*"
+}
+
+// PublishWorkflowRequest publishes a workflow execution request, tagging it
+// with the pod(s) already known (via SessionPodLookup) to own the session's
+// SSE connections so the workflow service can echo the header back and
+// publish its response directly instead of broadcasting.
 func (c *Client) PublishWorkflowRequest(req *models.WorkflowRequest) error {
 	subject := fmt.Sprintf("chat.workflow.execute.%s", req.SessionID)
 
@@ -58,12 +153,25 @@ func (c *Client) PublishWorkflowRequest(req *models.WorkflowRequest) error {
 		return fmt.Errorf("failed to marshal workflow request: %w", err)
 	}
 
-	if err := c.conn.Publish(subject, data); err != nil {
+	msg := &nats.Msg{Subject: subject, Data: data}
+
+	if c.sessionPods != nil {
+		pods, err := c.sessionPods.LookupSessionPods(req.SessionID)
+		if err != nil && c.logger != nil {
+			c.logger.Warn("Session pod lookup failed, response will fall back to broadcast", "session_id", req.SessionID, "error", err)
+		}
+		if len(pods) > 0 {
+			msg.Header = nats.Header{ownerPodsHeader: pods}
+		}
+	}
+
+	if err := c.conn.PublishMsg(msg); err != nil {
+		c.metrics.IncNATSPublishError()
 		return fmt.Errorf("failed to publish workflow request: %w", err)
 	}
 
 	if c.logger != nil {
-		c.logger.Printf("Published workflow request to %s (message_id=%s)", subject, req.MessageID)
+		c.logger.Info("Published workflow request", "subject", subject, "message_id", req.MessageID, "correlation_id", req.CorrelationID)
 	}
 
 	return nil
@@ -77,14 +185,17 @@ func (c *Client) SubscribeToResponses(handler ResponseHandler) error {
 		var chunk models.ResponseChunk
 		if err := json.Unmarshal(msg.Data, &chunk); err != nil {
 			if c.logger != nil {
-				c.logger.Printf("Failed to unmarshal response chunk: %v", err)
+				c.logger.Error("Failed to unmarshal response chunk", "error", err)
 			}
+			c.metrics.IncNATSSubscribeError()
 			return
 		}
 
+		c.mirrorChunk(&chunk)
+
 		if err := handler(&chunk); err != nil {
 			if c.logger != nil {
-				c.logger.Printf("Failed to handle response chunk: %v", err)
+				c.logger.Error("Failed to handle response chunk", "correlation_id", chunk.CorrelationID, "error", err)
 			}
 		}
 	})
@@ -94,7 +205,7 @@ func (c *Client) SubscribeToResponses(handler ResponseHandler) error {
 	}
 
 	if c.logger != nil {
-		c.logger.Printf("Subscribed to %s", subject)
+		c.logger.Info("Subscribed", "subject", subject)
 	}
 
 	return nil
@@ -108,15 +219,18 @@ func (c *Client) SubscribeToBroadcast(handler ResponseHandler) error {
 		var chunk models.ResponseChunk
 		if err := json.Unmarshal(msg.Data, &chunk); err != nil {
 			if c.logger != nil {
-				c.logger.Printf("Failed to unmarshal broadcast chunk: %v", err)
+				c.logger.Error("Failed to unmarshal broadcast chunk", "error", err)
 			}
+			c.metrics.IncNATSSubscribeError()
 			return
 		}
 
+		c.mirrorChunk(&chunk)
+
 		// Handler should check if this pod has active connections for the session
 		if err := handler(&chunk); err != nil {
 			if c.logger != nil {
-				c.logger.Printf("Failed to handle broadcast chunk: %v", err)
+				c.logger.Error("Failed to handle broadcast chunk", "correlation_id", chunk.CorrelationID, "error", err)
 			}
 		}
 	})
@@ -126,12 +240,181 @@ func (c *Client) SubscribeToBroadcast(handler ResponseHandler) error {
 	}
 
 	if c.logger != nil {
-		c.logger.Printf("Subscribed to %s", subject)
+		c.logger.Info("Subscribed", "subject", subject)
+	}
+
+	return nil
+}
+
+// cancelSubject returns the subject a cancellation for messageID is
+// published to; the workflow service subscribes to this directly, not via
+// the per-pod response plumbing above.
+func cancelSubject(messageID string) string {
+	return fmt.Sprintf("workflow.cancel.%s", messageID)
+}
+
+// PublishCancel tells the workflow service to stop generating the response
+// for messageID, e.g. because a client sent a cancel_message over /api/ws.
+func (c *Client) PublishCancel(messageID string) error {
+	subject := cancelSubject(messageID)
+
+	if err := c.conn.Publish(subject, nil); err != nil {
+		c.metrics.IncNATSPublishError()
+		return fmt.Errorf("failed to publish cancel for message %s: %w", messageID, err)
+	}
+
+	if c.logger != nil {
+		c.logger.Info("Published cancel", "subject", subject, "message_id", messageID)
 	}
 
 	return nil
 }
 
+// resendSubject returns the subject a resend request for messageID is
+// published to; the workflow service subscribes to this directly, not via
+// the per-pod response plumbing above.
+func resendSubject(messageID string) string {
+	return fmt.Sprintf("workflow.resend.%s", messageID)
+}
+
+// RequestResend asks the workflow service to re-publish chunkIDs of
+// messageID's response, e.g. because a buffer's gap has persisted past
+// MissingChunkTimeout. Implements buffer.ChunkRequester.
+func (c *Client) RequestResend(messageID string, chunkIDs []int) error {
+	subject := resendSubject(messageID)
+
+	data, err := json.Marshal(&models.ResendRequest{MessageID: messageID, ChunkIDs: chunkIDs})
+	if err != nil {
+		return fmt.Errorf("failed to marshal resend request for message %s: %w", messageID, err)
+	}
+
+	if err := c.conn.Publish(subject, data); err != nil {
+		c.metrics.IncNATSPublishError()
+		return fmt.Errorf("failed to publish resend request for message %s: %w", messageID, err)
+	}
+
+	if c.logger != nil {
+		c.logger.Info("Published resend request", "subject", subject, "message_id", messageID, "chunk_ids", chunkIDs)
+	}
+
+	return nil
+}
+
+// mirrorChunk durably persists a chunk into the per-session JetStream stream
+// and stamps it with the resulting stream sequence, so a later reconnect can
+// replay via ReplaySince. Mirroring is best-effort: a JetStream publish
+// failure is logged but never blocks live delivery of the chunk.
+func (c *Client) mirrorChunk(chunk *models.ResponseChunk) {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		if c.logger != nil {
+			c.logger.Error("Failed to marshal chunk for mirroring", "error", err)
+		}
+		return
+	}
+
+	ack, err := c.js.Publish(chunkSubject(chunk.SessionID), data)
+	if err != nil {
+		if c.logger != nil {
+			c.logger.Error("Failed to mirror chunk to JetStream", "error", err)
+		}
+		return
+	}
+
+	chunk.Sequence = ack.Sequence
+}
+
+// ReplaySince replays chunks mirrored for sessionID starting immediately
+// after lastSeq, calling emit for each in sequence order. It is used to
+// catch an SSE client up after a reconnect before switching it to live
+// delivery. lastSeq of 0 replays the entire retained stream for the session.
+func (c *Client) ReplaySince(sessionID string, lastSeq uint64, emit func(*models.ResponseChunk) error) error {
+	sub, err := c.js.SubscribeSync(chunkSubject(sessionID), nats.StartSequence(lastSeq+1), nats.ReplayInstant())
+	if err != nil {
+		return fmt.Errorf("failed to create replay subscription: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		pending, _, err := sub.Pending()
+		if err != nil {
+			return fmt.Errorf("failed to check replay backlog: %w", err)
+		}
+		if pending == 0 {
+			msg, err := sub.NextMsg(50 * time.Millisecond)
+			if err == nats.ErrTimeout {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read replay message: %w", err)
+			}
+			if err := c.deliverReplayMsg(msg, emit); err != nil {
+				return err
+			}
+			continue
+		}
+
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			return fmt.Errorf("failed to read replay message: %w", err)
+		}
+		if err := c.deliverReplayMsg(msg, emit); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) deliverReplayMsg(msg *nats.Msg, emit func(*models.ResponseChunk) error) error {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return fmt.Errorf("failed to read replay message metadata: %w", err)
+	}
+
+	var chunk models.ResponseChunk
+	if err := json.Unmarshal(msg.Data, &chunk); err != nil {
+		return fmt.Errorf("failed to unmarshal replayed chunk: %w", err)
+	}
+	chunk.Sequence = meta.Sequence.Stream
+
+	return emit(&chunk)
+}
+
+// PublishResponseChunk publishes a response chunk to the pod(s) that own
+// the chunk's session, per the configured PodLookup. If no PodLookup is
+// configured, or the lookup misses (e.g. presence expired, or this is the
+// chunk's first delivery before any pod has registered), it falls back to
+// the broadcast subject so every pod gets a chance to deliver it.
+func (c *Client) PublishResponseChunk(chunk *models.ResponseChunk) error {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response chunk: %w", err)
+	}
+
+	if c.podLookup != nil {
+		pods, err := c.podLookup.LookupPods(chunk.SessionID)
+		if err != nil && c.logger != nil {
+			c.logger.Warn("Pod lookup failed, falling back to broadcast", "session_id", chunk.SessionID, "error", err)
+		}
+		if len(pods) > 0 {
+			for _, podID := range pods {
+				subject := fmt.Sprintf("chat.pod.%s.response", podID)
+				if err := c.conn.Publish(subject, data); err != nil {
+					c.metrics.IncNATSPublishError()
+					return fmt.Errorf("failed to publish response chunk to pod %s: %w", podID, err)
+				}
+			}
+			return nil
+		}
+	}
+
+	subject := fmt.Sprintf("chat.session.%s.broadcast", chunk.SessionID)
+	if err := c.conn.Publish(subject, data); err != nil {
+		c.metrics.IncNATSPublishError()
+		return fmt.Errorf("failed to broadcast response chunk: %w", err)
+	}
+	return nil
+}
+
 // Close closes the NATS connection
 func (c *Client) Close() {
 	if c.conn != nil {
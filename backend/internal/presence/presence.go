@@ -0,0 +1,141 @@
+// Package presence tracks which pod (and connection) owns a session's SSE
+// connection, so a publisher can route a response directly to the owning
+// pod instead of broadcasting it to the whole fleet.
+package presence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PodConnection identifies a single SSE connection owned by a pod.
+type PodConnection struct {
+	PodID        string
+	ConnectionID string
+}
+
+// Presence tracks session -> (pod, connection) ownership with a TTL that
+// must be refreshed periodically (e.g. from the SSE heartbeat loop) or the
+// entry expires.
+type Presence interface {
+	// Register records that connectionID on podID is serving sessionID.
+	Register(sessionID, podID, connectionID string) error
+	// Deregister removes a single connection's ownership record.
+	Deregister(sessionID, podID, connectionID string) error
+	// Refresh renews the TTL on an existing ownership record.
+	Refresh(sessionID, podID, connectionID string) error
+	// Lookup returns every pod/connection pair currently serving sessionID.
+	Lookup(sessionID string) ([]PodConnection, error)
+	// LookupPods is a convenience wrapper returning the distinct pod IDs
+	// serving sessionID, for callers that only need routing information.
+	LookupPods(sessionID string) ([]string, error)
+}
+
+// defaultTTL is how long a presence entry survives without a refresh.
+const defaultTTL = 60 * time.Second
+
+// RedisPresence is a Presence backed by a Redis hash per session, keyed by
+// connection ID with the owning pod ID as the value.
+type RedisPresence struct {
+	client *redis.Client
+	ctx    context.Context
+	ttl    time.Duration
+}
+
+// NewRedisPresence creates a Redis-backed Presence implementation.
+func NewRedisPresence(addr, password string, db int, ttl time.Duration) (*RedisPresence, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	return &RedisPresence{client: client, ctx: ctx, ttl: ttl}, nil
+}
+
+func presenceKey(sessionID string) string {
+	return fmt.Sprintf("presence:session:%s", sessionID)
+}
+
+// Register implements Presence.
+func (p *RedisPresence) Register(sessionID, podID, connectionID string) error {
+	key := presenceKey(sessionID)
+	if err := p.client.HSet(p.ctx, key, connectionID, podID).Err(); err != nil {
+		return fmt.Errorf("failed to register presence: %w", err)
+	}
+	if err := p.client.Expire(p.ctx, key, p.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set presence expiration: %w", err)
+	}
+	return nil
+}
+
+// Deregister implements Presence.
+func (p *RedisPresence) Deregister(sessionID, podID, connectionID string) error {
+	key := presenceKey(sessionID)
+	if err := p.client.HDel(p.ctx, key, connectionID).Err(); err != nil {
+		return fmt.Errorf("failed to deregister presence: %w", err)
+	}
+	return nil
+}
+
+// Refresh implements Presence.
+func (p *RedisPresence) Refresh(sessionID, podID, connectionID string) error {
+	key := presenceKey(sessionID)
+	// Re-assert the field in case it expired out from under us, then renew the TTL.
+	if err := p.client.HSet(p.ctx, key, connectionID, podID).Err(); err != nil {
+		return fmt.Errorf("failed to refresh presence: %w", err)
+	}
+	if err := p.client.Expire(p.ctx, key, p.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to renew presence expiration: %w", err)
+	}
+	return nil
+}
+
+// Lookup implements Presence.
+func (p *RedisPresence) Lookup(sessionID string) ([]PodConnection, error) {
+	entries, err := p.client.HGetAll(p.ctx, presenceKey(sessionID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up presence: %w", err)
+	}
+
+	conns := make([]PodConnection, 0, len(entries))
+	for connectionID, podID := range entries {
+		conns = append(conns, PodConnection{PodID: podID, ConnectionID: connectionID})
+	}
+	return conns, nil
+}
+
+// LookupPods implements Presence.
+func (p *RedisPresence) LookupPods(sessionID string) ([]string, error) {
+	conns, err := p.Lookup(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(conns))
+	pods := make([]string, 0, len(conns))
+	for _, c := range conns {
+		if !seen[c.PodID] {
+			seen[c.PodID] = true
+			pods = append(pods, c.PodID)
+		}
+	}
+	return pods, nil
+}
+
+// Close closes the underlying Redis client.
+func (p *RedisPresence) Close() error {
+	return p.client.Close()
+}
@@ -0,0 +1,191 @@
+// Package ratelimit provides Redis-backed, distributed rate limiting and
+// connection-count capping keyed by user ID, so limits hold across pods
+// rather than just within a single process.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/rohitkeshwani07/chat/backend/internal/auth"
+)
+
+// tokenBucketScript atomically refills and debits a per-key token bucket.
+// KEYS[1] is the bucket's hash key; ARGV holds the refill rate (tokens per
+// second), the bucket capacity, the current unix time in seconds, and the
+// number of tokens requested. Returns 1 if the request is allowed, 0
+// otherwise.
+const tokenBucketScript = `
+local tokens = tonumber(redis.call("HGET", KEYS[1], "tokens"))
+local last = tonumber(redis.call("HGET", KEYS[1], "ts"))
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[4])
+
+if tokens == nil then
+	tokens = capacity
+	last = now
+end
+
+local elapsed = math.max(0, now - last)
+tokens = math.min(capacity, tokens + elapsed * rate)
+
+local allowed = 0
+if tokens >= requested then
+	tokens = tokens - requested
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tokens, "ts", now)
+redis.call("EXPIRE", KEYS[1], 3600)
+
+return allowed
+`
+
+// Limiter is a distributed token-bucket rate limiter keyed by an arbitrary
+// string, typically a user ID.
+type Limiter struct {
+	client   *redis.Client
+	rate     float64 // tokens refilled per second
+	capacity float64 // maximum burst size
+}
+
+// NewLimiter creates a Limiter allowing bursts of up to capacity requests,
+// refilling at rate tokens per second thereafter.
+func NewLimiter(client *redis.Client, rate, capacity float64) *Limiter {
+	return &Limiter{client: client, rate: rate, capacity: capacity}
+}
+
+// Allow reports whether key may proceed right now, debiting one token
+// from its bucket if so.
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, error) {
+	result, err := l.client.Eval(ctx, tokenBucketScript,
+		[]string{fmt.Sprintf("ratelimit:chat:%s", key)},
+		l.rate, l.capacity, time.Now().Unix(), 1,
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate rate limit for %s: %w", key, err)
+	}
+	return result == 1, nil
+}
+
+// Middleware rejects requests from the authenticated user (see
+// auth.UserIDFromContext) with 429 once their bucket is empty. It must be
+// installed behind an auth.Verifier's middleware.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.UserIDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Missing authenticated user", http.StatusUnauthorized)
+			return
+		}
+
+		allowed, err := l.Allow(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Rate limit check failed", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// connCountTTL bounds how long a connection-count key can survive without a
+// matching Release, e.g. because a pod crashed instead of running its
+// deferred cleanup. Without it, a leaked increment (or a Release that fails
+// for any reason) would never self-heal and would count against the user
+// forever.
+const connCountTTL = 24 * time.Hour
+
+// releaseTimeout bounds Release's own Redis call. Release always runs with
+// its own context rather than the caller's (see Release), so it needs a
+// bound of its own instead of inheriting one.
+const releaseTimeout = 5 * time.Second
+
+// ConnLimiter caps the number of concurrent connections a single user may
+// hold open at once, shared across pods via a Redis counter.
+type ConnLimiter struct {
+	client *redis.Client
+	max    int
+	logger *slog.Logger
+}
+
+// NewConnLimiter creates a ConnLimiter allowing up to max concurrent
+// connections per user. logger may be nil, in which case a failed Release
+// is silently ignored rather than logged.
+func NewConnLimiter(client *redis.Client, max int, logger *slog.Logger) *ConnLimiter {
+	return &ConnLimiter{client: client, max: max, logger: logger}
+}
+
+// TryAcquire increments userID's connection count and reports whether it's
+// still within the cap; if not, the increment is rolled back.
+func (l *ConnLimiter) TryAcquire(ctx context.Context, userID string) (bool, error) {
+	key := fmt.Sprintf("ratelimit:conns:%s", userID)
+
+	count, err := l.client.Incr(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to increment connection count for %s: %w", userID, err)
+	}
+	l.client.Expire(ctx, key, connCountTTL)
+
+	if count > int64(l.max) {
+		l.client.Decr(ctx, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+// Release decrements userID's connection count after it disconnects. It
+// runs against its own short-lived context rather than one passed in by the
+// caller: Middleware defers this call against the request's context, which
+// is already canceled by the time a normal disconnect unblocks
+// next.ServeHTTP, so using that context here would make every normal
+// disconnect fail to decrement and permanently leak a connection slot.
+func (l *ConnLimiter) Release(userID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	key := fmt.Sprintf("ratelimit:conns:%s", userID)
+	if err := l.client.Decr(ctx, key).Err(); err != nil {
+		if l.logger != nil {
+			l.logger.Error("Failed to release connection slot", "user_id", userID, "error", err)
+		}
+	}
+}
+
+// Middleware enforces the connection cap for the life of the wrapped
+// handler's call to next.ServeHTTP, which for an SSE handler blocks until
+// the client disconnects. It must be installed behind an auth.Verifier's
+// middleware.
+func (l *ConnLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.UserIDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Missing authenticated user", http.StatusUnauthorized)
+			return
+		}
+
+		allowed, err := l.TryAcquire(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Connection limit check failed", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Too many concurrent connections for this user", http.StatusTooManyRequests)
+			return
+		}
+		defer l.Release(userID)
+
+		next.ServeHTTP(w, r)
+	})
+}
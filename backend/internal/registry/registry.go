@@ -2,115 +2,193 @@ package registry
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rohitkeshwani07/chat/backend/internal/models"
+	"github.com/rohitkeshwani07/chat/backend/internal/registry/scripts"
 )
 
-// SessionRegistry manages active SSE connections using Redis
+// connectionTTL bounds the session set, pod hash, and their membership
+// entries; both RegisterConnection and UpdateHeartbeat renew it.
+const connectionTTL = 5 * time.Minute
+
+// heartbeatTTL bounds the separate per-connection heartbeat key.
+const heartbeatTTL = 30 * time.Second
+
+// SessionRegistry manages active SSE connections using Redis. It's backed
+// by one or more independent Redis shards: session-scoped keys
+// (session:connections:{sid}, session:meta:{sid}) hash on session ID, and
+// pod-scoped keys (pod:connections:{podID}, heartbeat:{podID}:{connID})
+// hash on pod ID, so all keys for a given session (or pod) always land on
+// the same shard.
 type SessionRegistry struct {
-	client *redis.Client
+	shards []*redis.Client
 	ctx    context.Context
 }
 
-// New creates a new session registry
+// New creates a session registry backed by a single Redis node. It's a thin
+// wrapper over NewSharded with one shard, so existing callers don't need to
+// change to pick up sharding.
 func New(addr, password string, db int) (*SessionRegistry, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
-	})
+	return NewSharded([]string{addr}, password, db)
+}
 
-	ctx := context.Background()
+// NewSharded creates a session registry backed by one *redis.Client per
+// address in addrs. Every key SessionRegistry writes is routed through
+// shardFor on its session or pod ID component, so scaling beyond a single
+// Redis node just means adding addresses here.
+func NewSharded(addrs []string, password string, db int) (*SessionRegistry, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("registry: at least one Redis address is required")
+	}
 
-	// Test connection
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	ctx := context.Background()
+	shards := make([]*redis.Client, len(addrs))
+	for i, addr := range addrs {
+		client := redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: password,
+			DB:       db,
+		})
+		if err := client.Ping(ctx).Err(); err != nil {
+			for _, opened := range shards[:i] {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to connect to Redis shard %s: %w", addr, err)
+		}
+		shards[i] = client
 	}
 
 	return &SessionRegistry{
-		client: client,
+		shards: shards,
 		ctx:    ctx,
 	}, nil
 }
 
-// RegisterConnection registers a new SSE connection
-func (r *SessionRegistry) RegisterConnection(conn *models.ActiveConnection) error {
-	// Key pattern: session:connections:{session_id}
-	sessionKey := fmt.Sprintf("session:connections:%s", conn.SessionID)
-
-	// Value: pod_id:connection_id
-	value := fmt.Sprintf("%s:%s", conn.PodID, conn.ConnectionID)
-
-	// Add to set with TTL of 5 minutes
-	if err := r.client.SAdd(r.ctx, sessionKey, value).Err(); err != nil {
-		return fmt.Errorf("failed to register connection: %w", err)
-	}
+// sessionShard returns the shard that owns every key for sessionID.
+func (r *SessionRegistry) sessionShard(sessionID string) *redis.Client {
+	return r.shards[shardFor(sessionID, len(r.shards))]
+}
 
-	// Set expiration on the key (renewed on heartbeat)
-	if err := r.client.Expire(r.ctx, sessionKey, 5*time.Minute).Err(); err != nil {
-		return fmt.Errorf("failed to set expiration: %w", err)
-	}
+// podShard returns the shard that owns every key for podID.
+func (r *SessionRegistry) podShard(podID string) *redis.Client {
+	return r.shards[shardFor(podID, len(r.shards))]
+}
 
-	// Also store in pod:connections:{pod_id} hash
+// RegisterConnection registers a new SSE connection. The session-set update
+// and the pod-hash/heartbeat update are each a single round-trip Lua script
+// (see the scripts subpackage); sharding session keys and pod keys
+// separately (chunk2-4) means they can land on different Redis nodes, so
+// there's no single round trip spanning both the way there was before
+// sharding.
+//
+// That leaves a window, if the process crashes or the request times out
+// between the two calls, where one update applied and the other didn't.
+// The two orderings aren't equally bad: pod-hash/heartbeat first, then
+// session membership, means the failure mode is a connection that's fully
+// registered but not yet visible in its session's active-pod list — at
+// worst it's invisible to routing for one heartbeat interval, since
+// UpdateHeartbeat re-asserts session membership too. The other order
+// (session first) would instead risk a phantom session member routing to a
+// pod hash/heartbeat that was never created, which looks "active" to
+// GetActivePods for up to the full connectionTTL. So pod/heartbeat is
+// registered first.
+func (r *SessionRegistry) RegisterConnection(conn *models.ActiveConnection) error {
+	sessionKey := fmt.Sprintf("session:connections:%s", conn.SessionID)
 	podKey := fmt.Sprintf("pod:connections:%s", conn.PodID)
-	if err := r.client.HSet(r.ctx, podKey, conn.ConnectionID, conn.SessionID).Err(); err != nil {
-		return fmt.Errorf("failed to register in pod hash: %w", err)
-	}
-	if err := r.client.Expire(r.ctx, podKey, 5*time.Minute).Err(); err != nil {
-		return fmt.Errorf("failed to set pod hash expiration: %w", err)
-	}
-
-	// Store heartbeat
 	heartbeatKey := fmt.Sprintf("heartbeat:%s:%s", conn.PodID, conn.ConnectionID)
-	if err := r.client.Set(r.ctx, heartbeatKey, time.Now().Unix(), 30*time.Second).Err(); err != nil {
-		return fmt.Errorf("failed to set heartbeat: %w", err)
+	value := connValue(conn.PodID, conn.ConnectionID)
+
+	if err := scripts.RegisterPodHeartbeat(r.ctx, r.podShard(conn.PodID), podKey, heartbeatKey, value, conn.ConnectionID, time.Now().Unix(), connectionTTL, heartbeatTTL); err != nil {
+		return fmt.Errorf("failed to register pod heartbeat: %w", err)
+	}
+	if err := scripts.RegisterSessionMembership(r.ctx, r.sessionShard(conn.SessionID), sessionKey, value, connectionTTL); err != nil {
+		return fmt.Errorf("failed to register session membership: %w", err)
 	}
 
 	return nil
 }
 
-// DeregisterConnection removes a connection from the registry
+// DeregisterConnection removes a connection from the registry, also
+// dropping the session set once it's empty. Session membership is removed
+// first, then the pod hash/heartbeat, the same ordering rationale as
+// RegisterConnection: if this is interrupted partway through, the
+// connection stops being routable immediately (good — the client is
+// disconnecting) rather than staying visible in the session's active-pod
+// list with no guarantee its pod hash/heartbeat entry still exists.
 func (r *SessionRegistry) DeregisterConnection(sessionID, podID, connectionID string) error {
-	// Remove from session:connections:{session_id}
 	sessionKey := fmt.Sprintf("session:connections:%s", sessionID)
-	value := fmt.Sprintf("%s:%s", podID, connectionID)
-	if err := r.client.SRem(r.ctx, sessionKey, value).Err(); err != nil {
-		return fmt.Errorf("failed to deregister from session: %w", err)
-	}
-
-	// Remove from pod:connections:{pod_id}
 	podKey := fmt.Sprintf("pod:connections:%s", podID)
-	if err := r.client.HDel(r.ctx, podKey, connectionID).Err(); err != nil {
-		return fmt.Errorf("failed to deregister from pod hash: %w", err)
-	}
-
-	// Remove heartbeat
 	heartbeatKey := fmt.Sprintf("heartbeat:%s:%s", podID, connectionID)
-	if err := r.client.Del(r.ctx, heartbeatKey).Err(); err != nil {
-		return fmt.Errorf("failed to delete heartbeat: %w", err)
+	value := connValue(podID, connectionID)
+
+	if err := scripts.DeregisterSessionMembership(r.ctx, r.sessionShard(sessionID), sessionKey, value); err != nil {
+		return fmt.Errorf("failed to deregister session membership: %w", err)
+	}
+	if err := scripts.DeregisterPodHeartbeat(r.ctx, r.podShard(podID), podKey, heartbeatKey, connectionID); err != nil {
+		return fmt.Errorf("failed to deregister pod heartbeat: %w", err)
 	}
 
 	return nil
 }
 
-// UpdateHeartbeat updates the heartbeat timestamp for a connection
-func (r *SessionRegistry) UpdateHeartbeat(podID, connectionID string) error {
+// UpdateHeartbeat refreshes a connection's heartbeat timestamp and
+// re-asserts its session/pod membership, so a heartbeat can never extend a
+// heartbeat key whose set/hash membership was already removed by a racing
+// deregistration. Pod/heartbeat is refreshed before session membership, the
+// same ordering as RegisterConnection and for the same reason — and since
+// every live connection heartbeats well inside heartbeatTTL, this call is
+// also what self-heals the "registered but not yet session-visible" window
+// RegisterConnection's ordering can leave behind.
+func (r *SessionRegistry) UpdateHeartbeat(sessionID, podID, connectionID string) error {
+	sessionKey := fmt.Sprintf("session:connections:%s", sessionID)
+	podKey := fmt.Sprintf("pod:connections:%s", podID)
 	heartbeatKey := fmt.Sprintf("heartbeat:%s:%s", podID, connectionID)
-	if err := r.client.Set(r.ctx, heartbeatKey, time.Now().Unix(), 30*time.Second).Err(); err != nil {
+	value := connValue(podID, connectionID)
+
+	if err := scripts.HeartbeatPodHeartbeat(r.ctx, r.podShard(podID), podKey, heartbeatKey, value, connectionID, time.Now().Unix(), connectionTTL, heartbeatTTL); err != nil {
 		return fmt.Errorf("failed to update heartbeat: %w", err)
 	}
+	if err := scripts.HeartbeatSessionMembership(r.ctx, r.sessionShard(sessionID), sessionKey, value, connectionTTL); err != nil {
+		return fmt.Errorf("failed to refresh session membership: %w", err)
+	}
+
 	return nil
 }
 
+// connValue encodes a session set member as "{podID}|{connID}". Parsed back
+// by parseConnValue via strings.LastIndex on "|", rather than assuming
+// connID has any particular length.
+func connValue(podID, connID string) string {
+	return podID + "|" + connID
+}
+
+// parseConnValue decodes a session set member written by connValue. Members
+// written before this format change are tolerated for the length of a
+// rolling deploy: they have no "|" at all, so they fall back to the old
+// heuristic of treating the last 36 characters as a UUID connection ID.
+// They age out on their own once connectionTTL elapses, so this is only a
+// read-time compatibility shim, not a permanent second format.
+func parseConnValue(value string) (podID, connID string, ok bool) {
+	if idx := strings.LastIndex(value, "|"); idx >= 0 {
+		return value[:idx], value[idx+1:], true
+	}
+	if len(value) > 37 {
+		return value[:len(value)-37], value[len(value)-36:], true
+	}
+	return "", "", false
+}
+
 // GetActivePods returns a list of pod IDs that have active connections for a session
 func (r *SessionRegistry) GetActivePods(sessionID string) ([]string, error) {
 	sessionKey := fmt.Sprintf("session:connections:%s", sessionID)
 
 	// Get all members of the set
-	members, err := r.client.SMembers(r.ctx, sessionKey).Result()
+	members, err := r.sessionShard(sessionID).SMembers(r.ctx, sessionKey).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get active pods: %w", err)
 	}
@@ -118,10 +196,7 @@ func (r *SessionRegistry) GetActivePods(sessionID string) ([]string, error) {
 	// Extract unique pod IDs
 	podMap := make(map[string]bool)
 	for _, member := range members {
-		// member format: pod_id:connection_id
-		// Extract pod_id (everything before last colon)
-		podID := member[:len(member)-len(member[len(member)-36:])-1] // Assuming UUID connection ID
-		if len(podID) > 0 {
+		if podID, _, ok := parseConnValue(member); ok && len(podID) > 0 {
 			podMap[podID] = true
 		}
 	}
@@ -134,12 +209,41 @@ func (r *SessionRegistry) GetActivePods(sessionID string) ([]string, error) {
 	return pods, nil
 }
 
+// GetActiveConnections returns the full (pod ID, connection ID) pairs for a
+// session's active connections, for callers that need both halves rather
+// than just the deduplicated pod list GetActivePods returns. Only
+// SessionID, PodID, and ConnectionID are populated: the session set doesn't
+// carry the rest of models.ActiveConnection's fields.
+func (r *SessionRegistry) GetActiveConnections(sessionID string) ([]models.ActiveConnection, error) {
+	sessionKey := fmt.Sprintf("session:connections:%s", sessionID)
+
+	members, err := r.sessionShard(sessionID).SMembers(r.ctx, sessionKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active connections: %w", err)
+	}
+
+	conns := make([]models.ActiveConnection, 0, len(members))
+	for _, member := range members {
+		podID, connID, ok := parseConnValue(member)
+		if !ok || podID == "" || connID == "" {
+			continue
+		}
+		conns = append(conns, models.ActiveConnection{
+			SessionID:    sessionID,
+			PodID:        podID,
+			ConnectionID: connID,
+		})
+	}
+
+	return conns, nil
+}
+
 // GetPodConnections returns all connection IDs for a specific pod
 func (r *SessionRegistry) GetPodConnections(podID string) (map[string]string, error) {
 	podKey := fmt.Sprintf("pod:connections:%s", podID)
 
 	// Get all connections for this pod
-	connections, err := r.client.HGetAll(r.ctx, podKey).Result()
+	connections, err := r.podShard(podID).HGetAll(r.ctx, podKey).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pod connections: %w", err)
 	}
@@ -150,6 +254,7 @@ func (r *SessionRegistry) GetPodConnections(podID string) (map[string]string, er
 // CacheSessionMetadata stores session metadata in Redis
 func (r *SessionRegistry) CacheSessionMetadata(session *models.ChatSession) error {
 	key := fmt.Sprintf("session:meta:%s", session.SessionID)
+	shard := r.sessionShard(session.SessionID)
 
 	data := map[string]interface{}{
 		"user_id":     session.UserID,
@@ -157,12 +262,12 @@ func (r *SessionRegistry) CacheSessionMetadata(session *models.ChatSession) erro
 		"model_name":  session.ModelName,
 	}
 
-	if err := r.client.HSet(r.ctx, key, data).Err(); err != nil {
+	if err := shard.HSet(r.ctx, key, data).Err(); err != nil {
 		return fmt.Errorf("failed to cache session metadata: %w", err)
 	}
 
 	// Set TTL of 1 hour
-	if err := r.client.Expire(r.ctx, key, 1*time.Hour).Err(); err != nil {
+	if err := shard.Expire(r.ctx, key, 1*time.Hour).Err(); err != nil {
 		return fmt.Errorf("failed to set metadata expiration: %w", err)
 	}
 
@@ -173,7 +278,7 @@ func (r *SessionRegistry) CacheSessionMetadata(session *models.ChatSession) erro
 func (r *SessionRegistry) GetSessionMetadata(sessionID string) (map[string]string, error) {
 	key := fmt.Sprintf("session:meta:%s", sessionID)
 
-	data, err := r.client.HGetAll(r.ctx, key).Result()
+	data, err := r.sessionShard(sessionID).HGetAll(r.ctx, key).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get session metadata: %w", err)
 	}
@@ -181,7 +286,54 @@ func (r *SessionRegistry) GetSessionMetadata(sessionID string) (map[string]strin
 	return data, nil
 }
 
-// Close closes the Redis connection
+// LookupSessionPods returns the pod(s) currently serving sessionID's SSE
+// connections, per the same connection-tracking keys GetActivePods reads.
+// It's used to tag outgoing workflow requests with the currently-known
+// owning pod(s) (see nats.Client.SetSessionPodLookup), so the workflow
+// service can publish its response directly to those pods instead of
+// broadcasting to every pod in the deployment.
+func (r *SessionRegistry) LookupSessionPods(sessionID string) ([]string, error) {
+	return r.GetActivePods(sessionID)
+}
+
+// Ping checks connectivity to every shard, for use by health checks.
+func (r *SessionRegistry) Ping() error {
+	var errs []error
+	for _, shard := range r.shards {
+		if err := shard.Ping(r.ctx).Err(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ShardConnectionStats returns each shard's go-redis connection pool stats,
+// in shard order, so callers can report per-shard connection counts (e.g.
+// to Prometheus) without SessionRegistry needing to depend on the metrics
+// package itself.
+func (r *SessionRegistry) ShardConnectionStats() []*redis.PoolStats {
+	stats := make([]*redis.PoolStats, len(r.shards))
+	for i, shard := range r.shards {
+		stats[i] = shard.PoolStats()
+	}
+	return stats
+}
+
+// Client returns the underlying Redis client for subsystems (e.g.
+// ratelimit) that need to share a connection pool without the registry's
+// higher-level key patterns. It always returns the first shard, so it only
+// makes sense to rely on in single-shard deployments.
+func (r *SessionRegistry) Client() *redis.Client {
+	return r.shards[0]
+}
+
+// Close closes every shard's Redis connection.
 func (r *SessionRegistry) Close() error {
-	return r.client.Close()
+	var errs []error
+	for _, shard := range r.shards {
+		if err := shard.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
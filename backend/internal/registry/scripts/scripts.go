@@ -0,0 +1,115 @@
+// Package scripts holds the Lua scripts SessionRegistry runs against Redis
+// so a multi-key update (set membership, hash membership, and a heartbeat
+// key, each with their own TTL) happens atomically in a single round trip
+// instead of as several independent commands a crash could interleave.
+// Each script is wrapped in a *redis.Script, which transparently uses
+// EVALSHA (falling back to EVAL and re-caching on a cache miss), so callers
+// never need to think about script SHAs.
+//
+// The session-membership update and the pod/heartbeat update are separate
+// scripts, each keyed differently (by session ID and pod ID respectively),
+// so a sharded SessionRegistry can route them to different shards: they can
+// no longer share one round trip, but each remains atomic on its own shard.
+package scripts
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sessionMembership atomically (re-)asserts a connection's session
+// membership with a fresh TTL. It backs both RegisterSessionMembership and
+// HeartbeatSessionMembership.
+//
+// KEYS: [1] sessionKey
+// ARGV: [1] value (pod_id|connection_id)  [2] session TTL seconds
+var sessionMembership = redis.NewScript(`
+redis.call('SADD', KEYS[1], ARGV[1])
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// podHeartbeat atomically (re-)asserts a connection's pod membership and
+// refreshes its heartbeat, both with fresh TTLs. It backs both
+// RegisterPodHeartbeat and HeartbeatPodHeartbeat, so a heartbeat never
+// extends a heartbeat key's TTL without also rewriting the connection's pod
+// hash entry. The HSET is unconditional: a heartbeat that runs after a
+// deregistration re-adds the connection until its fresh TTLs expire.
+//
+// KEYS: [1] podKey  [2] heartbeatKey
+// ARGV: [1] value (pod_id|connection_id)  [2] connID
+//
+//	[3] heartbeat timestamp  [4] pod TTL seconds  [5] heartbeat TTL seconds
+var podHeartbeat = redis.NewScript(`
+redis.call('HSET', KEYS[1], ARGV[2], ARGV[1])
+redis.call('EXPIRE', KEYS[1], ARGV[4])
+redis.call('SET', KEYS[2], ARGV[3], 'EX', ARGV[5])
+return 1
+`)
+
+// sessionDeregister atomically removes a connection's session membership,
+// dropping the session set entirely once it's empty so a finished session
+// doesn't leave a bare key behind.
+//
+// KEYS: [1] sessionKey
+// ARGV: [1] value (pod_id|connection_id)
+var sessionDeregister = redis.NewScript(`
+redis.call('SREM', KEYS[1], ARGV[1])
+if redis.call('SCARD', KEYS[1]) == 0 then
+	redis.call('DEL', KEYS[1])
+end
+return 1
+`)
+
+// podHeartbeatDeregister atomically removes a connection's pod membership
+// and heartbeat key.
+//
+// KEYS: [1] podKey  [2] heartbeatKey
+// ARGV: [1] connID
+var podHeartbeatDeregister = redis.NewScript(`
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('DEL', KEYS[2])
+return 1
+`)
+
+// RegisterSessionMembership atomically performs the SADD/EXPIRE sequence
+// RegisterConnection needs against sessionKey's shard.
+func RegisterSessionMembership(ctx context.Context, client *redis.Client, sessionKey, value string, sessionTTL time.Duration) error {
+	return sessionMembership.Run(ctx, client, []string{sessionKey}, value, int64(sessionTTL.Seconds())).Err()
+}
+
+// HeartbeatSessionMembership re-asserts a connection's session membership
+// and refreshes its TTL, via the same script RegisterSessionMembership runs.
+func HeartbeatSessionMembership(ctx context.Context, client *redis.Client, sessionKey, value string, sessionTTL time.Duration) error {
+	return sessionMembership.Run(ctx, client, []string{sessionKey}, value, int64(sessionTTL.Seconds())).Err()
+}
+
+// RegisterPodHeartbeat atomically performs the HSET/EXPIRE/SET sequence
+// RegisterConnection needs against podKey/heartbeatKey's shard.
+func RegisterPodHeartbeat(ctx context.Context, client *redis.Client, podKey, heartbeatKey, value, connID string, ts int64, podTTL, heartbeatTTL time.Duration) error {
+	return podHeartbeat.Run(ctx, client, []string{podKey, heartbeatKey},
+		value, connID, ts, int64(podTTL.Seconds()), int64(heartbeatTTL.Seconds())).Err()
+}
+
+// HeartbeatPodHeartbeat atomically re-asserts a connection's pod membership
+// and refreshes its heartbeat and membership TTLs, via the same script
+// RegisterPodHeartbeat runs.
+func HeartbeatPodHeartbeat(ctx context.Context, client *redis.Client, podKey, heartbeatKey, value, connID string, ts int64, podTTL, heartbeatTTL time.Duration) error {
+	return podHeartbeat.Run(ctx, client, []string{podKey, heartbeatKey},
+		value, connID, ts, int64(podTTL.Seconds()), int64(heartbeatTTL.Seconds())).Err()
+}
+
+// DeregisterSessionMembership atomically performs the SREM sequence
+// DeregisterConnection needs against sessionKey's shard, conditionally
+// dropping the now-empty session set in the same round trip.
+func DeregisterSessionMembership(ctx context.Context, client *redis.Client, sessionKey, value string) error {
+	return sessionDeregister.Run(ctx, client, []string{sessionKey}, value).Err()
+}
+
+// DeregisterPodHeartbeat atomically performs the HDEL/DEL sequence
+// DeregisterConnection needs against podKey/heartbeatKey's shard.
+func DeregisterPodHeartbeat(ctx context.Context, client *redis.Client, podKey, heartbeatKey, connID string) error {
+	return podHeartbeatDeregister.Run(ctx, client, []string{podKey, heartbeatKey}, connID).Err()
+}
@@ -0,0 +1,16 @@
+package registry
+
+import "hash/fnv"
+
+// shardFor deterministically maps key to one of numShards shards using
+// FNV-1a, so every key derived from the same ID (session or pod) always
+// lands on the same shard. It doesn't need to be cryptographically strong,
+// just stable and evenly distributed.
+func shardFor(key string, numShards int) int {
+	if numShards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(numShards))
+}
@@ -3,48 +3,216 @@ package sse
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/rohitkeshwani07/chat/backend/internal/metrics"
 	"github.com/rohitkeshwani07/chat/backend/internal/models"
+	"github.com/rohitkeshwani07/chat/backend/internal/transport"
 )
 
-// Connection represents an active SSE connection
+// defaultSendQueueSize is the per-connection buffered channel size used
+// when Manager isn't given an explicit one.
+const defaultSendQueueSize = 256
+
+// defaultWriteTimeout bounds a single write to a connection's socket so a
+// wedged TCP connection can't pin its writer goroutine forever.
+const defaultWriteTimeout = 10 * time.Second
+
+// ChunkReplayer replays previously delivered chunks for a session starting
+// after lastSeq, calling emit for each one in order. Implemented by
+// nats.Client against its JetStream mirror, and by
+// buffer.DistributedManager against its Redis Stream mirror.
+type ChunkReplayer interface {
+	ReplaySince(sessionID string, lastSeq uint64, emit func(*models.ResponseChunk) error) error
+}
+
+// Presence tracks which pod+connection owns a session, so a publisher can
+// route directly to the owning pod instead of broadcasting. Implemented by
+// presence.RedisPresence; see that package for the Redis-backed details.
+type Presence interface {
+	Register(sessionID, podID, connectionID string) error
+	Deregister(sessionID, podID, connectionID string) error
+	Refresh(sessionID, podID, connectionID string) error
+}
+
+// Connection represents an active SSE connection, implementing
+// transport.Connection. Events are never written to Writer directly by
+// callers; they're enqueued onto sendCh and written by the dedicated writer
+// goroutine started in Register, so one slow client can't stall delivery to
+// anyone else.
 type Connection struct {
-	ID         string
-	SessionID  string
-	UserID     string
-	Writer     http.ResponseWriter
-	Flusher    http.Flusher
-	Done       chan struct{}
-	CreatedAt  time.Time
-	LastSent   time.Time
-	ClientIP   string
-	UserAgent  string
-}
-
-// Manager manages all active SSE connections
+	ID        string
+	SessionID string
+	UserID    string
+	Writer    http.ResponseWriter
+	Flusher   http.Flusher
+	Done      chan struct{}
+	CreatedAt time.Time
+	LastSent  time.Time
+	ClientIP  string
+	UserAgent string
+
+	sendCh       chan *models.SSEEvent
+	writeTimeout time.Duration
+	logger       *slog.Logger
+	closeOnce    sync.Once
+	writeMu      sync.Mutex
+}
+
+// ConnID, Session, User, RemoteAddr, Agent, Opened, Closed, and Queue
+// implement transport.Connection by exposing the equivalent fields above.
+func (c *Connection) ConnID() string {
+	return c.ID
+}
+
+func (c *Connection) Session() string {
+	return c.SessionID
+}
+
+func (c *Connection) User() string {
+	return c.UserID
+}
+
+func (c *Connection) RemoteAddr() string {
+	return c.ClientIP
+}
+
+func (c *Connection) Agent() string {
+	return c.UserAgent
+}
+
+func (c *Connection) Opened() time.Time {
+	return c.CreatedAt
+}
+
+func (c *Connection) Closed() <-chan struct{} {
+	return c.Done
+}
+
+func (c *Connection) Queue() chan *models.SSEEvent {
+	return c.sendCh
+}
+
+// Close idempotently closes Done; it's called both by the writer goroutine
+// on a failed write and by the SSE handler's own request-context teardown.
+func (c *Connection) Close() {
+	c.closeOnce.Do(func() { close(c.Done) })
+}
+
+// Write formats event as an SSE frame and writes it directly to the
+// connection's socket, bounded by writeTimeout. Only Manager's writer
+// goroutine and its overflow path call this; everything else goes through
+// Queue. writeMu serializes the two, since the overflow path can run
+// concurrently with the writer goroutine (it fires when the queue is
+// already full, i.e. likely while the writer is itself blocked on a Write
+// of its own), and neither http.ResponseWriter nor its Flusher are safe for
+// concurrent use.
+func (c *Connection) Write(event *models.SSEEvent) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if rc := http.NewResponseController(c.Writer); c.writeTimeout > 0 {
+		if err := rc.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil && c.logger != nil {
+			c.logger.Warn("Failed to set write deadline", "conn_id", c.ID, "error", err)
+		}
+	}
+
+	var message string
+
+	if event.ID != "" {
+		message += fmt.Sprintf("id: %s\n", event.ID)
+	}
+
+	if event.Event != "" {
+		message += fmt.Sprintf("event: %s\n", event.Event)
+	}
+
+	dataJSON, err := json.Marshal(event.Data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event data: %w", err)
+	}
+
+	message += fmt.Sprintf("data: %s\n\n", string(dataJSON))
+
+	if _, err := fmt.Fprint(c.Writer, message); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+
+	c.Flusher.Flush()
+	c.LastSent = time.Now()
+
+	return nil
+}
+
+// Manager manages all active connections, SSE and WebSocket alike, via the
+// transport.Connection interface.
 type Manager struct {
-	connections map[string]*Connection // connection_id -> connection
-	sessions    map[string][]*Connection // session_id -> connections
-	mu          sync.RWMutex
-	logger      *log.Logger
+	connections        map[string]transport.Connection // connection_id -> connection
+	sessions           map[string][]transport.Connection // session_id -> connections
+	mu                 sync.RWMutex
+	logger             *slog.Logger
+	replayer           ChunkReplayer
+	presence           Presence
+	podID              string
+	sendQueueSize      int
+	writeTimeout       time.Duration
+	droppedConnections int64
+	metrics            *metrics.Registry
+
+	heartbeatMu     sync.Mutex
+	heartbeatTicker *time.Ticker
 }
 
-// NewManager creates a new SSE connection manager
-func NewManager(logger *log.Logger) *Manager {
+// NewManager creates a new SSE connection manager. replayer and presence
+// may both be nil: without a replayer, reconnecting clients skip straight
+// to live delivery; without presence, this pod's connections aren't
+// published for cross-pod routing (see Presence). sendQueueSize and
+// writeTimeout fall back to sensible defaults when <= 0. metricsRegistry
+// may be nil, in which case metrics collection is simply skipped.
+func NewManager(logger *slog.Logger, replayer ChunkReplayer, presence Presence, podID string, sendQueueSize int, writeTimeout time.Duration, metricsRegistry *metrics.Registry) *Manager {
+	if sendQueueSize <= 0 {
+		sendQueueSize = defaultSendQueueSize
+	}
+	if writeTimeout <= 0 {
+		writeTimeout = defaultWriteTimeout
+	}
+
 	return &Manager{
-		connections: make(map[string]*Connection),
-		sessions:    make(map[string][]*Connection),
-		logger:      logger,
+		connections:   make(map[string]transport.Connection),
+		sessions:      make(map[string][]transport.Connection),
+		logger:        logger,
+		replayer:      replayer,
+		presence:      presence,
+		podID:         podID,
+		sendQueueSize: sendQueueSize,
+		writeTimeout:  writeTimeout,
+		metrics:       metricsRegistry,
 	}
 }
 
-// AddConnection registers a new SSE connection
-func (m *Manager) AddConnection(sessionID, userID string, w http.ResponseWriter, r *http.Request) (*Connection, error) {
+// SetReplayer wires (or replaces) the ChunkReplayer used to resume
+// reconnecting clients. It exists because a distributed buffer.Manager
+// isn't available until after the buffer layer is constructed, which
+// itself depends on this Manager — so main.go builds the Manager first
+// with whatever replayer the message bus already provides (nil for
+// RabbitMQ) and calls this afterward once the buffer layer is wired up.
+// Like NewManager's replayer param, it isn't safe to call once connections
+// are already being served.
+func (m *Manager) SetReplayer(replayer ChunkReplayer) {
+	m.replayer = replayer
+}
+
+// AddConnection registers a new SSE connection. lastEventID is the resume
+// cursor sent by the client (from the Last-Event-ID header or a query
+// param fallback); when non-empty, ResumeFrom replays any chunks the
+// client missed before the connection starts receiving live chunks.
+func (m *Manager) AddConnection(sessionID, userID string, w http.ResponseWriter, r *http.Request, lastEventID string) (*Connection, error) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		return nil, fmt.Errorf("streaming not supported")
@@ -57,37 +225,95 @@ func (m *Manager) AddConnection(sessionID, userID string, w http.ResponseWriter,
 	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
 
 	conn := &Connection{
-		ID:        uuid.New().String(),
-		SessionID: sessionID,
-		UserID:    userID,
-		Writer:    w,
-		Flusher:   flusher,
-		Done:      make(chan struct{}),
-		CreatedAt: time.Now(),
-		LastSent:  time.Now(),
-		ClientIP:  r.RemoteAddr,
-		UserAgent: r.UserAgent(),
+		ID:           uuid.New().String(),
+		SessionID:    sessionID,
+		UserID:       userID,
+		Writer:       w,
+		Flusher:      flusher,
+		Done:         make(chan struct{}),
+		CreatedAt:    time.Now(),
+		LastSent:     time.Now(),
+		ClientIP:     r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+		sendCh:       make(chan *models.SSEEvent, m.sendQueueSize),
+		writeTimeout: m.writeTimeout,
+		logger:       m.logger,
 	}
 
+	m.Register(conn, lastEventID)
+
+	return conn, nil
+}
+
+// Register adds an already-constructed connection of any transport to the
+// manager: maps it by ID and session, starts its writer goroutine, sends
+// the initial "connected" event, replays missed chunks if lastEventID is
+// set, and registers it with presence. AddConnection calls this after
+// performing the SSE-specific HTTP upgrade; ws.Connection is constructed by
+// the caller (see handlers.HandleWS) and registered here directly.
+func (m *Manager) Register(conn transport.Connection, lastEventID string) {
 	m.mu.Lock()
-	m.connections[conn.ID] = conn
-	m.sessions[sessionID] = append(m.sessions[sessionID], conn)
+	m.connections[conn.ConnID()] = conn
+	m.sessions[conn.Session()] = append(m.sessions[conn.Session()], conn)
 	m.mu.Unlock()
 
+	m.metrics.SetActiveConnections(m.podID, m.GetConnectionCount())
+	m.metrics.SetActiveSessions(m.podID, m.GetSessionCount())
+
+	go m.runWriter(conn)
+
 	if m.logger != nil {
-		m.logger.Printf("Added SSE connection: %s for session %s", conn.ID, sessionID)
+		m.logger.Info("Added connection", "conn_id", conn.ConnID(), "session_id", conn.Session())
 	}
 
 	// Send initial connection event
-	m.SendEvent(conn.ID, &models.SSEEvent{
+	m.SendEvent(conn.ConnID(), &models.SSEEvent{
 		Event: "connected",
 		Data: map[string]string{
-			"connection_id": conn.ID,
-			"session_id":    sessionID,
+			"connection_id": conn.ConnID(),
+			"session_id":    conn.Session(),
 		},
 	})
 
-	return conn, nil
+	if lastEventID != "" {
+		if err := m.ResumeFrom(conn, lastEventID); err != nil && m.logger != nil {
+			m.logger.Warn("Failed to resume connection", "conn_id", conn.ConnID(), "session_id", conn.Session(), "last_event_id", lastEventID, "error", err)
+		}
+	}
+
+	if m.presence != nil {
+		if err := m.presence.Register(conn.Session(), m.podID, conn.ConnID()); err != nil && m.logger != nil {
+			m.logger.Warn("Failed to register presence", "conn_id", conn.ConnID(), "error", err)
+		}
+	}
+}
+
+// ResumeFrom replays any chunks mirrored for conn's session after
+// lastEventID to conn alone, then returns so the caller can switch to live
+// delivery. It is a no-op if the manager has no configured ChunkReplayer.
+//
+// Replay is scoped to the single reconnecting connection, not broadcast to
+// the whole session: a session can have other connections already live
+// (multiple tabs/devices), and they've never missed these chunks, so
+// resending to them would duplicate delivery every time any one connection
+// reconnects.
+func (m *Manager) ResumeFrom(conn transport.Connection, lastEventID string) error {
+	if m.replayer == nil {
+		return nil
+	}
+
+	lastSeq, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid last event id %q: %w", lastEventID, err)
+	}
+
+	return m.replayer.ReplaySince(conn.Session(), lastSeq, func(chunk *models.ResponseChunk) error {
+		return m.SendEvent(conn.ConnID(), &models.SSEEvent{
+			Event: "chunk",
+			Data:  chunk,
+			ID:    strconv.FormatUint(chunk.Sequence, 10),
+		})
+	})
 }
 
 // RemoveConnection removes a connection
@@ -104,29 +330,44 @@ func (m *Manager) RemoveConnection(connectionID string) {
 	delete(m.connections, connectionID)
 
 	// Remove from sessions map
-	if conns, ok := m.sessions[conn.SessionID]; ok {
+	if conns, ok := m.sessions[conn.Session()]; ok {
 		for i, c := range conns {
-			if c.ID == connectionID {
-				m.sessions[conn.SessionID] = append(conns[:i], conns[i+1:]...)
+			if c.ConnID() == connectionID {
+				m.sessions[conn.Session()] = append(conns[:i], conns[i+1:]...)
 				break
 			}
 		}
 
 		// Clean up empty session entry
-		if len(m.sessions[conn.SessionID]) == 0 {
-			delete(m.sessions, conn.SessionID)
+		if len(m.sessions[conn.Session()]) == 0 {
+			delete(m.sessions, conn.Session())
 		}
 	}
 
-	// Close the done channel
-	close(conn.Done)
+	// Tear the connection down (idempotent: RemoveConnection can be called
+	// both by the writer goroutine on a failed write and by the handler's
+	// own request-context teardown)
+	conn.Close()
+
+	if m.presence != nil {
+		if err := m.presence.Deregister(conn.Session(), m.podID, conn.ConnID()); err != nil && m.logger != nil {
+			m.logger.Warn("Failed to deregister presence", "conn_id", conn.ConnID(), "error", err)
+		}
+	}
 
 	if m.logger != nil {
-		m.logger.Printf("Removed SSE connection: %s", connectionID)
+		m.logger.Info("Removed connection", "conn_id", connectionID)
 	}
+
+	m.metrics.SetActiveConnections(m.podID, m.GetConnectionCount())
+	m.metrics.SetActiveSessions(m.podID, m.GetSessionCount())
 }
 
-// SendEvent sends an SSE event to a specific connection
+// SendEvent enqueues an SSE event for a specific connection. The actual
+// write happens asynchronously on the connection's writer goroutine. The
+// one exception is a full queue: the overflow frame is then written to the
+// client's socket on the calling goroutine (see handleOverflow), bounded by
+// the connection's write timeout.
 func (m *Manager) SendEvent(connectionID string, event *models.SSEEvent) error {
 	m.mu.RLock()
 	conn, exists := m.connections[connectionID]
@@ -136,10 +377,11 @@ func (m *Manager) SendEvent(connectionID string, event *models.SSEEvent) error {
 		return fmt.Errorf("connection not found: %s", connectionID)
 	}
 
-	return m.writeEvent(conn, event)
+	m.enqueue(conn, event)
+	return nil
 }
 
-// SendToSession sends an event to all connections for a session
+// SendToSession enqueues an event for every connection on a session
 func (m *Manager) SendToSession(sessionID string, event *models.SSEEvent) error {
 	m.mu.RLock()
 	conns := m.sessions[sessionID]
@@ -149,17 +391,75 @@ func (m *Manager) SendToSession(sessionID string, event *models.SSEEvent) error
 		return fmt.Errorf("no connections for session: %s", sessionID)
 	}
 
-	var lastErr error
 	for _, conn := range conns {
-		if err := m.writeEvent(conn, event); err != nil {
-			lastErr = err
-			if m.logger != nil {
-				m.logger.Printf("Failed to send to connection %s: %v", conn.ID, err)
+		m.enqueue(conn, event)
+	}
+
+	return nil
+}
+
+// enqueue hands an event to a connection's bounded send queue without
+// waiting for space. If the queue is full the client is considered too slow
+// to keep up: it's sent a best-effort `event: overflow` frame, written
+// synchronously, and evicted.
+func (m *Manager) enqueue(conn transport.Connection, event *models.SSEEvent) {
+	select {
+	case conn.Queue() <- event:
+	default:
+		m.handleOverflow(conn)
+	}
+}
+
+// handleOverflow evicts a connection whose send queue is full.
+func (m *Manager) handleOverflow(conn transport.Connection) {
+	atomic.AddInt64(&m.droppedConnections, 1)
+	m.metrics.IncEventsDropped("queue_full")
+
+	if m.logger != nil {
+		m.logger.Warn("Send queue overflow, dropping connection", "conn_id", conn.ConnID())
+	}
+
+	// Best effort: try to let the client know why it's being disconnected,
+	// bypassing the (already full) queue. Ignore the error - the socket may
+	// already be gone.
+	_ = conn.Write(&models.SSEEvent{
+		Event: "overflow",
+		Data:  map[string]string{"reason": "send queue full"},
+	})
+
+	m.RemoveConnection(conn.ConnID())
+}
+
+// GetDroppedConnectionCount returns how many connections have been evicted
+// for a full send queue since the manager started.
+func (m *Manager) GetDroppedConnectionCount() int64 {
+	return atomic.LoadInt64(&m.droppedConnections)
+}
+
+// runWriter owns conn's underlying socket for its lifetime, draining its
+// send queue and writing each event in turn. handleOverflow can also call
+// conn.Write directly, concurrently with this goroutine; conn.Write itself
+// is responsible for serializing the two (see sse.Connection.Write and
+// ws.Connection.Write).
+func (m *Manager) runWriter(conn transport.Connection) {
+	for {
+		select {
+		case <-conn.Closed():
+			return
+		case event := <-conn.Queue():
+			start := time.Now()
+			if err := conn.Write(event); err != nil {
+				if m.logger != nil {
+					m.logger.Warn("Write failed", "conn_id", conn.ConnID(), "error", err)
+				}
+				m.metrics.IncSSESendError()
+				m.RemoveConnection(conn.ConnID())
+				return
 			}
+			m.metrics.ObserveWriteLatencySeconds(time.Since(start).Seconds())
+			m.metrics.IncEventsSent(event.Event)
 		}
 	}
-
-	return lastErr
 }
 
 // SendChunk sends a response chunk to all connections for a session
@@ -167,48 +467,43 @@ func (m *Manager) SendChunk(sessionID string, chunk *models.ResponseChunk) error
 	event := &models.SSEEvent{
 		Event: "chunk",
 		Data:  chunk,
+		ID:    strconv.FormatUint(chunk.Sequence, 10),
 	}
 
 	return m.SendToSession(sessionID, event)
 }
 
-// writeEvent writes an SSE event to a connection
-func (m *Manager) writeEvent(conn *Connection, event *models.SSEEvent) error {
-	// Format SSE message
-	var message string
-
-	if event.ID != "" {
-		message += fmt.Sprintf("id: %s\n", event.ID)
-	}
-
-	if event.Event != "" {
-		message += fmt.Sprintf("event: %s\n", event.Event)
-	}
-
-	// Marshal data to JSON
-	dataJSON, err := json.Marshal(event.Data)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event data: %w", err)
+// SendGap notifies a session that a chunk buffer has a persistent gap,
+// listing the chunk IDs still missing. Implements buffer.GapNotifier.
+func (m *Manager) SendGap(sessionID, messageID string, missingChunkIDs []int) error {
+	event := &models.SSEEvent{
+		Event: "gap",
+		Data: map[string]interface{}{
+			"message_id": messageID,
+			"missing":    missingChunkIDs,
+		},
 	}
 
-	message += fmt.Sprintf("data: %s\n\n", string(dataJSON))
+	return m.SendToSession(sessionID, event)
+}
 
-	// Write to connection
-	if _, err := fmt.Fprint(conn.Writer, message); err != nil {
-		return fmt.Errorf("failed to write event: %w", err)
+// SendFailure notifies a session that a chunk buffer has been given up on
+// after repeated failed resend attempts, so the client can stop waiting
+// instead of hanging forever. Implements buffer.GapNotifier.
+func (m *Manager) SendFailure(sessionID, messageID, reason string) error {
+	event := &models.SSEEvent{
+		Event: "failure",
+		Data: map[string]interface{}{
+			"message_id": messageID,
+			"reason":     reason,
+		},
 	}
 
-	// Flush immediately
-	conn.Flusher.Flush()
-
-	// Update last sent time
-	conn.LastSent = time.Now()
-
-	return nil
+	return m.SendToSession(sessionID, event)
 }
 
 // GetConnection retrieves a connection by ID
-func (m *Manager) GetConnection(connectionID string) (*Connection, bool) {
+func (m *Manager) GetConnection(connectionID string) (transport.Connection, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	conn, exists := m.connections[connectionID]
@@ -216,7 +511,7 @@ func (m *Manager) GetConnection(connectionID string) (*Connection, bool) {
 }
 
 // GetSessionConnections returns all connections for a session
-func (m *Manager) GetSessionConnections(sessionID string) []*Connection {
+func (m *Manager) GetSessionConnections(sessionID string) []transport.Connection {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	return m.sessions[sessionID]
@@ -243,10 +538,10 @@ func (m *Manager) GetSessionCount() int {
 	return len(m.sessions)
 }
 
-// SendHeartbeat sends a heartbeat/ping to all connections
+// SendHeartbeat enqueues a heartbeat/ping to all connections
 func (m *Manager) SendHeartbeat() {
 	m.mu.RLock()
-	conns := make([]*Connection, 0, len(m.connections))
+	conns := make([]transport.Connection, 0, len(m.connections))
 	for _, conn := range m.connections {
 		conns = append(conns, conn)
 	}
@@ -260,21 +555,39 @@ func (m *Manager) SendHeartbeat() {
 	}
 
 	for _, conn := range conns {
-		if err := m.writeEvent(conn, event); err != nil {
-			if m.logger != nil {
-				m.logger.Printf("Heartbeat failed for connection %s: %v", conn.ID, err)
+		m.enqueue(conn, event)
+
+		if m.presence != nil {
+			if err := m.presence.Refresh(conn.Session(), m.podID, conn.ConnID()); err != nil && m.logger != nil {
+				m.logger.Warn("Failed to refresh presence", "conn_id", conn.ConnID(), "error", err)
 			}
-			// Connection likely dead, will be cleaned up
 		}
 	}
 }
 
-// StartHeartbeat starts sending periodic heartbeats
+// StartHeartbeat starts sending periodic heartbeats. The interval can be
+// changed later, without restarting the goroutine, via SetHeartbeatInterval.
 func (m *Manager) StartHeartbeat(interval time.Duration) {
 	ticker := time.NewTicker(interval)
+
+	m.heartbeatMu.Lock()
+	m.heartbeatTicker = ticker
+	m.heartbeatMu.Unlock()
+
 	go func() {
 		for range ticker.C {
 			m.SendHeartbeat()
 		}
 	}()
 }
+
+// SetHeartbeatInterval retunes the running heartbeat ticker, e.g. in
+// response to a config hot-reload. It is a no-op if StartHeartbeat hasn't
+// been called yet.
+func (m *Manager) SetHeartbeatInterval(interval time.Duration) {
+	m.heartbeatMu.Lock()
+	defer m.heartbeatMu.Unlock()
+	if m.heartbeatTicker != nil {
+		m.heartbeatTicker.Reset(interval)
+	}
+}
@@ -0,0 +1,198 @@
+// Package postgres implements storage.MessageStore and storage.Retainer
+// against the chat_sessions/messages tables created by cmd/migrate.
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/rohitkeshwani07/chat/backend/internal/models"
+)
+
+// Store is a storage.MessageStore backed by PostgreSQL.
+type Store struct {
+	db *sql.DB
+}
+
+// New opens a connection pool to dsn and verifies it with a Ping.
+func New(dsn string) (*Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the underlying connection pool.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping verifies the database connection is still alive. Implements
+// storage.Pinger.
+func (s *Store) Ping() error {
+	return s.db.Ping()
+}
+
+// SaveSession upserts session, refreshing every mutable column on conflict.
+func (s *Store) SaveSession(session *models.ChatSession) error {
+	_, err := s.db.Exec(`
+		INSERT INTO chat_sessions (session_id, user_id, title, ai_provider, model_name, created_at, updated_at, last_message_at, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (session_id) DO UPDATE SET
+			title = EXCLUDED.title,
+			ai_provider = EXCLUDED.ai_provider,
+			model_name = EXCLUDED.model_name,
+			updated_at = EXCLUDED.updated_at,
+			last_message_at = EXCLUDED.last_message_at,
+			is_active = EXCLUDED.is_active`,
+		session.SessionID, session.UserID, session.Title, session.AIProvider, session.ModelName,
+		session.CreatedAt, session.UpdatedAt, session.LastMessageAt, session.IsActive,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save session %s: %w", session.SessionID, err)
+	}
+	return nil
+}
+
+// GetSession returns the session with the given ID.
+func (s *Store) GetSession(sessionID string) (*models.ChatSession, error) {
+	var session models.ChatSession
+	err := s.db.QueryRow(`
+		SELECT session_id, user_id, title, ai_provider, model_name, created_at, updated_at, last_message_at, is_active
+		FROM chat_sessions WHERE session_id = $1`,
+		sessionID,
+	).Scan(
+		&session.SessionID, &session.UserID, &session.Title, &session.AIProvider, &session.ModelName,
+		&session.CreatedAt, &session.UpdatedAt, &session.LastMessageAt, &session.IsActive,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session %s: %w", sessionID, err)
+	}
+	return &session, nil
+}
+
+// ListSessions returns up to limit sessions for userID, most recent first.
+// A zero before lists from the most recent session; a non-zero before
+// excludes sessions created at or after it, for keyset pagination.
+func (s *Store) ListSessions(userID string, limit int, before time.Time) ([]*models.ChatSession, error) {
+	query := `
+		SELECT session_id, user_id, title, ai_provider, model_name, created_at, updated_at, last_message_at, is_active
+		FROM chat_sessions WHERE user_id = $1`
+	args := []interface{}{userID}
+
+	if !before.IsZero() {
+		query += " AND created_at < $2"
+		args = append(args, before)
+	}
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args)+1)
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions for user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var sessions []*models.ChatSession
+	for rows.Next() {
+		var session models.ChatSession
+		if err := rows.Scan(
+			&session.SessionID, &session.UserID, &session.Title, &session.AIProvider, &session.ModelName,
+			&session.CreatedAt, &session.UpdatedAt, &session.LastMessageAt, &session.IsActive,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan session row: %w", err)
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, rows.Err()
+}
+
+// SaveMessage inserts message, ignoring duplicates (messages are immutable
+// once written, so a redelivered chunk-complete event is a no-op).
+func (s *Store) SaveMessage(message *models.Message) error {
+	metadata, err := json.Marshal(message.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata for message %s: %w", message.MessageID, err)
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO messages (message_id, session_id, role, content, token_count, metadata, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (message_id) DO NOTHING`,
+		message.MessageID, message.SessionID, message.Role, message.Content, message.TokenCount, metadata, message.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save message %s: %w", message.MessageID, err)
+	}
+	return nil
+}
+
+// ListMessages returns up to limit messages for sessionID, most recent
+// first. A zero before lists from the most recent message; a non-zero
+// before excludes messages created at or after it, for keyset pagination.
+func (s *Store) ListMessages(sessionID string, limit int, before time.Time) ([]*models.Message, error) {
+	query := `
+		SELECT message_id, session_id, role, content, token_count, metadata, created_at
+		FROM messages WHERE session_id = $1`
+	args := []interface{}{sessionID}
+
+	if !before.IsZero() {
+		query += " AND created_at < $2"
+		args = append(args, before)
+	}
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args)+1)
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages for session %s: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	var messages []*models.Message
+	for rows.Next() {
+		var message models.Message
+		var metadata []byte
+		if err := rows.Scan(
+			&message.MessageID, &message.SessionID, &message.Role, &message.Content,
+			&message.TokenCount, &metadata, &message.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan message row: %w", err)
+		}
+		if len(metadata) > 0 {
+			if err := json.Unmarshal(metadata, &message.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal metadata for message %s: %w", message.MessageID, err)
+			}
+		}
+		messages = append(messages, &message)
+	}
+	return messages, rows.Err()
+}
+
+// DeleteOlderThan removes sessions (and, via ON DELETE CASCADE, their
+// messages) whose last activity is before the given time. Implements
+// storage.Retainer.
+func (s *Store) DeleteOlderThan(before time.Time) (int64, error) {
+	result, err := s.db.Exec(
+		`DELETE FROM chat_sessions WHERE COALESCE(last_message_at, created_at) < $1`,
+		before,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions older than %s: %w", before, err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,38 @@
+// Package storage persists chat sessions and messages behind a pluggable
+// MessageStore interface, and provides a write-behind decorator so the SSE
+// hot path never blocks on a database round trip.
+package storage
+
+import (
+	"time"
+
+	"github.com/rohitkeshwani07/chat/backend/internal/models"
+)
+
+// MessageStore persists chat sessions and messages. before, where present,
+// is an exclusive cursor on created_at for pagination; its zero value means
+// "no cursor, start from the most recent".
+type MessageStore interface {
+	SaveSession(session *models.ChatSession) error
+	GetSession(sessionID string) (*models.ChatSession, error)
+	ListSessions(userID string, limit int, before time.Time) ([]*models.ChatSession, error)
+
+	SaveMessage(message *models.Message) error
+	ListMessages(sessionID string, limit int, before time.Time) ([]*models.Message, error)
+}
+
+// Retainer is implemented by stores that support trimming data older than
+// a retention window. Implementing it is optional: StartRetention no-ops
+// against a store that doesn't.
+type Retainer interface {
+	// DeleteOlderThan removes sessions (and their messages) whose last
+	// activity is before the given time, returning how many were removed.
+	DeleteOlderThan(before time.Time) (int64, error)
+}
+
+// Pinger is implemented by stores that can verify their connection is
+// still alive. Implementing it is optional: HandleHealthz skips the
+// database check against a store that doesn't.
+type Pinger interface {
+	Ping() error
+}
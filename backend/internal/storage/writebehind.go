@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/rohitkeshwani07/chat/backend/internal/models"
+)
+
+// defaultQueueSize is the write-behind queue depth used when NewWriteBehind
+// isn't given an explicit one.
+const defaultQueueSize = 1000
+
+// WriteBehind wraps a MessageStore so SaveSession/SaveMessage calls are
+// queued and applied by a single background worker instead of blocking the
+// caller (the SSE/HandleChat hot path) on a database round trip. Reads pass
+// straight through since callers need their results synchronously.
+type WriteBehind struct {
+	store  MessageStore
+	logger *slog.Logger
+	queue  chan func() error
+	done   chan struct{}
+}
+
+// NewWriteBehind starts the background worker and returns the wrapper.
+// queueSize <= 0 falls back to defaultQueueSize.
+func NewWriteBehind(store MessageStore, queueSize int, logger *slog.Logger) *WriteBehind {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+
+	wb := &WriteBehind{
+		store:  store,
+		logger: logger,
+		queue:  make(chan func() error, queueSize),
+		done:   make(chan struct{}),
+	}
+	go wb.run()
+	return wb
+}
+
+func (w *WriteBehind) run() {
+	for {
+		select {
+		case fn := <-w.queue:
+			if err := fn(); err != nil && w.logger != nil {
+				w.logger.Error("Write-behind persistence failed", "error", err)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// enqueue hands fn to the background worker without blocking. If the queue
+// is full, the write is dropped rather than stalling the caller; this
+// mirrors sse.Manager's overflow handling for the same reason (a slow
+// downstream shouldn't be able to back up the hot path).
+func (w *WriteBehind) enqueue(fn func() error) error {
+	select {
+	case w.queue <- fn:
+		return nil
+	default:
+		if w.logger != nil {
+			w.logger.Warn("Write-behind queue full, dropping a write")
+		}
+		return fmt.Errorf("write-behind queue full")
+	}
+}
+
+// SaveSession queues store.SaveSession(session) for asynchronous persistence.
+func (w *WriteBehind) SaveSession(session *models.ChatSession) error {
+	return w.enqueue(func() error { return w.store.SaveSession(session) })
+}
+
+// SaveMessage queues store.SaveMessage(message) for asynchronous persistence.
+func (w *WriteBehind) SaveMessage(message *models.Message) error {
+	return w.enqueue(func() error { return w.store.SaveMessage(message) })
+}
+
+// GetSession passes straight through to the underlying store.
+func (w *WriteBehind) GetSession(sessionID string) (*models.ChatSession, error) {
+	return w.store.GetSession(sessionID)
+}
+
+// ListSessions passes straight through to the underlying store.
+func (w *WriteBehind) ListSessions(userID string, limit int, before time.Time) ([]*models.ChatSession, error) {
+	return w.store.ListSessions(userID, limit, before)
+}
+
+// ListMessages passes straight through to the underlying store.
+func (w *WriteBehind) ListMessages(sessionID string, limit int, before time.Time) ([]*models.Message, error) {
+	return w.store.ListMessages(sessionID, limit, before)
+}
+
+// DeleteOlderThan passes through to the underlying store if it implements
+// Retainer, so StartRetention works the same whether it's handed a raw
+// store or one wrapped in WriteBehind.
+func (w *WriteBehind) DeleteOlderThan(before time.Time) (int64, error) {
+	retainer, ok := w.store.(Retainer)
+	if !ok {
+		return 0, nil
+	}
+	return retainer.DeleteOlderThan(before)
+}
+
+// Ping passes through to the underlying store if it implements Pinger, so
+// HandleHealthz's database check works the same whether it's handed a raw
+// store or one wrapped in WriteBehind.
+func (w *WriteBehind) Ping() error {
+	pinger, ok := w.store.(Pinger)
+	if !ok {
+		return nil
+	}
+	return pinger.Ping()
+}
+
+// Close stops the background worker. Queued writes that haven't run yet are
+// dropped.
+func (w *WriteBehind) Close() {
+	close(w.done)
+}
+
+// StartRetention runs DeleteOlderThan on store every interval, removing
+// data older than retention. It's a no-op (returning a no-op stop func) if
+// store doesn't implement Retainer.
+func StartRetention(store MessageStore, retention, interval time.Duration, logger *slog.Logger) (stop func()) {
+	retainer, ok := store.(Retainer)
+	if !ok {
+		return func() {}
+	}
+
+	stopCh := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				deleted, err := retainer.DeleteOlderThan(time.Now().Add(-retention))
+				if err != nil {
+					if logger != nil {
+						logger.Error("Retention cleanup failed", "error", err)
+					}
+					continue
+				}
+				if deleted > 0 && logger != nil {
+					logger.Info("Retention cleanup removed sessions", "deleted", deleted, "retention", retention)
+				}
+			}
+		}
+	}()
+
+	return func() { close(stopCh) }
+}
@@ -0,0 +1,91 @@
+// Package tracing wires OpenTelemetry distributed tracing across the async
+// chat pipeline. A span started in handlers.HandleChat is serialized into
+// models.WorkflowRequest.TraceParent, carried across the message bus, and
+// resumed in handlers.HandleResponseChunk so a single trace covers both the
+// HTTP request and the workflow service's asynchronous reply.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/rohitkeshwani07/chat/backend"
+
+// propagator serializes a span context to and from the single traceparent
+// string models.WorkflowRequest and models.ResponseChunk carry.
+var propagator = propagation.TraceContext{}
+
+// Init installs the global TracerProvider when enabled is true, exporting
+// spans via OTLP/gRPC to endpoint (e.g. a Jaeger or Tempo collector). When
+// enabled is false, it leaves OpenTelemetry's default no-op provider in
+// place, so StartSpan/Inject/Extract are always safe to call unconditionally
+// regardless of config. The returned shutdown flushes and closes the
+// exporter; it's a no-op when tracing is disabled.
+func Init(ctx context.Context, enabled bool, endpoint, serviceName, podID string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagator)
+
+	if !enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceInstanceID(podID),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// StartSpan starts a span named name as a child of whatever span (if any) is
+// already carried by ctx.
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name)
+}
+
+// Inject serializes the span context in ctx into a W3C traceparent header
+// value, for threading through models.WorkflowRequest.TraceParent. It
+// returns "" when ctx carries no span, which StartSpan treats the same as
+// any other missing parent.
+func Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier["traceparent"]
+}
+
+// Extract rebuilds a context carrying the span described by traceParent, so
+// a span started against the returned context (see StartSpan) resumes the
+// original trace instead of starting a new one. An empty or malformed
+// traceParent is returned unchanged, and StartSpan then just begins a new
+// trace.
+func Extract(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{"traceparent": traceParent}
+	return propagator.Extract(ctx, carrier)
+}
@@ -0,0 +1,56 @@
+// Package transport defines the interface a client-facing streaming
+// connection must satisfy to be managed by sse.Manager, so the manager's
+// session bookkeeping, presence wiring, and chunk fan-out work the same way
+// regardless of whether a given client is attached over SSE or WebSocket.
+package transport
+
+import (
+	"time"
+
+	"github.com/rohitkeshwani07/chat/backend/internal/models"
+)
+
+// Connection is implemented by both sse.Connection and ws.Connection.
+// Manager only depends on this interface, never on a concrete transport, so
+// a session's SSE and WebSocket connections can be tracked and published to
+// side by side.
+type Connection interface {
+	ConnID() string
+	Session() string
+	User() string
+	RemoteAddr() string
+	Agent() string
+	Opened() time.Time
+
+	// Closed is closed once the connection has been torn down, by either
+	// end.
+	Closed() <-chan struct{}
+
+	// Close idempotently tears the connection down, closing the channel
+	// returned by Closed.
+	Close()
+
+	// Queue is the connection's bounded outbound event queue. Manager
+	// enqueues onto it without blocking; the writer goroutine Manager
+	// starts for the connection drains it and calls Write for each event.
+	Queue() chan *models.SSEEvent
+
+	// Write formats and writes a single event to the underlying socket,
+	// bounded by the connection's own write deadline. Only the writer
+	// goroutine and Manager's overflow path call this directly (everything
+	// else goes through Queue), and implementations must serialize the two
+	// themselves, since the overflow path can run concurrently with the
+	// writer goroutine.
+	Write(event *models.SSEEvent) error
+}
+
+// InboundMessage is a client->server message received over a bidirectional
+// transport. SSE is receive-only, so today only ws.Connection produces
+// these. Type is one of "cancel_message", "typing", or "heartbeat_ack".
+type InboundMessage struct {
+	Type      string `json:"type"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// InboundHandler processes a client->server message received on conn.
+type InboundHandler func(conn Connection, msg *InboundMessage) error
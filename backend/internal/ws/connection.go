@@ -0,0 +1,198 @@
+// Package ws implements transport.Connection over WebSocket, for browsers
+// behind proxies that mangle SSE and clients that need to send messages
+// back (typing indicators, mid-stream cancellation) rather than just
+// receive them.
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/rohitkeshwani07/chat/backend/internal/models"
+	"github.com/rohitkeshwani07/chat/backend/internal/transport"
+)
+
+// defaultSendQueueSize is the per-connection buffered channel size used
+// when Upgrade isn't given an explicit one.
+const defaultSendQueueSize = 256
+
+// defaultWriteTimeout bounds a single write to a connection's socket so a
+// wedged TCP connection can't pin its writer goroutine forever.
+const defaultWriteTimeout = 10 * time.Second
+
+// upgrader upgrades an HTTP request to a WebSocket connection. Origin
+// checking is left to whatever reverse proxy and auth middleware front this
+// service, matching how HandleSSE already trusts its caller.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// Connection represents an active WebSocket connection, implementing
+// transport.Connection. Outbound events are never written to conn directly;
+// they're enqueued onto sendCh and written by the writer goroutine
+// sse.Manager starts via Register, so one slow client can't stall delivery
+// to anyone else. Unlike sse.Connection, ReadPump also runs for the life of
+// the connection, decoding inbound client messages.
+type Connection struct {
+	ID        string
+	SessionID string
+	UserID    string
+	Done      chan struct{}
+	CreatedAt time.Time
+	ClientIP  string
+	UserAgent string
+
+	conn         *websocket.Conn
+	sendCh       chan *models.SSEEvent
+	writeTimeout time.Duration
+	logger       *slog.Logger
+	closeOnce    sync.Once
+	writeMu      sync.Mutex
+}
+
+// Upgrade upgrades r into a WebSocket connection for sessionID/userID.
+// sendQueueSize and writeTimeout fall back to sensible defaults when <= 0.
+func Upgrade(w http.ResponseWriter, r *http.Request, sessionID, userID string, sendQueueSize int, writeTimeout time.Duration, logger *slog.Logger) (*Connection, error) {
+	if sendQueueSize <= 0 {
+		sendQueueSize = defaultSendQueueSize
+	}
+	if writeTimeout <= 0 {
+		writeTimeout = defaultWriteTimeout
+	}
+
+	wsConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upgrade to websocket: %w", err)
+	}
+
+	return &Connection{
+		ID:           uuid.New().String(),
+		SessionID:    sessionID,
+		UserID:       userID,
+		Done:         make(chan struct{}),
+		CreatedAt:    time.Now(),
+		ClientIP:     r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+		conn:         wsConn,
+		sendCh:       make(chan *models.SSEEvent, sendQueueSize),
+		writeTimeout: writeTimeout,
+		logger:       logger,
+	}, nil
+}
+
+// ConnID, Session, User, RemoteAddr, Agent, Opened, Closed, and Queue
+// implement transport.Connection by exposing the equivalent fields above.
+func (c *Connection) ConnID() string {
+	return c.ID
+}
+
+func (c *Connection) Session() string {
+	return c.SessionID
+}
+
+func (c *Connection) User() string {
+	return c.UserID
+}
+
+func (c *Connection) RemoteAddr() string {
+	return c.ClientIP
+}
+
+func (c *Connection) Agent() string {
+	return c.UserAgent
+}
+
+func (c *Connection) Opened() time.Time {
+	return c.CreatedAt
+}
+
+func (c *Connection) Closed() <-chan struct{} {
+	return c.Done
+}
+
+func (c *Connection) Queue() chan *models.SSEEvent {
+	return c.sendCh
+}
+
+// Close idempotently closes Done and the underlying socket; it's called
+// both by the writer goroutine on a failed write and by the handler's own
+// request-context teardown.
+func (c *Connection) Close() {
+	c.closeOnce.Do(func() {
+		close(c.Done)
+		c.conn.Close()
+	})
+}
+
+// eventFrame is the JSON shape written for every event, mirroring the
+// {event, data, id} fields sse.Connection sends over the wire as SSE frame
+// headers, so a single client library can parse either transport the same
+// way.
+type eventFrame struct {
+	Event string      `json:"event,omitempty"`
+	ID    string      `json:"id,omitempty"`
+	Data  interface{} `json:"data"`
+}
+
+// Write marshals event as a JSON text frame and writes it directly to the
+// connection's socket, bounded by writeTimeout. Only sse.Manager's writer
+// goroutine and its overflow path call this; everything else goes through
+// Queue. writeMu serializes the two: gorilla/websocket forbids concurrent
+// writers on the same connection, and the overflow path can run
+// concurrently with the writer goroutine (it fires when the queue is
+// already full, i.e. likely while the writer is itself blocked on a Write
+// of its own).
+func (c *Connection) Write(event *models.SSEEvent) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.writeTimeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil && c.logger != nil {
+			c.logger.Warn("Failed to set write deadline", "conn_id", c.ID, "error", err)
+		}
+	}
+
+	return c.conn.WriteJSON(&eventFrame{Event: event.Event, ID: event.ID, Data: event.Data})
+}
+
+// ReadPump reads client->server messages until the connection closes or
+// errors, decoding each as a transport.InboundMessage and dispatching it to
+// handler. It owns conn's read side for the connection's lifetime, the way
+// sse.Manager's writer goroutine owns the write side, and calls onClose
+// (typically the handler's own cleanup) once the read loop ends.
+func (c *Connection) ReadPump(handler transport.InboundHandler, onClose func()) {
+	defer onClose()
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if c.logger != nil {
+				c.logger.Warn("WebSocket read failed", "conn_id", c.ID, "error", err)
+			}
+			return
+		}
+
+		var msg transport.InboundMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			if c.logger != nil {
+				c.logger.Warn("Failed to unmarshal inbound message", "conn_id", c.ID, "error", err)
+			}
+			continue
+		}
+
+		if handler == nil {
+			continue
+		}
+		if err := handler(c, &msg); err != nil && c.logger != nil {
+			c.logger.Warn("Failed to handle inbound message", "type", msg.Type, "conn_id", c.ID, "error", err)
+		}
+	}
+}